@@ -3,311 +3,1981 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/json"
 	"fmt"
+	"io"
+	"net/url"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
+	"golang.org/x/term"
 )
 
 var withPackages []string
+var installArgs []string
+var failOnInstallWarning bool
+var assetPatterns []string
+var keepTemp bool
+var dependencyReportPath string
+var retryOnCrash int
+var resolveSymlinks bool
+var prewarmTypes bool
+var dependencyHashOnly bool
+var useSandbox bool
+var dedupeWith string
+var onMissHook string
+var onHitHook string
+var deterministic bool
+var printInstallPlan bool
+var assumeYes bool
+var scriptName string
+var minBun string
+var transformHeaderCmd string
+var keepPackageJSON string
+var runMode string
+var failOnMissingTypes bool
+var execWrapper string
+var limitMemory string
+var limitCPU int
+var scriptStdin string
+var captureMetricsPath string
+var aliasFlags []string
+var failOnEmptyDeps bool
+var bunInstallCache string
+var printTreeHash bool
+var maxInstallOutputLines int
+var verifyScriptHash string
+var dependencyBlocklist string
+var writeSBOMPath string
+var rerunLast bool
+var editLast bool
+var mergeEnvFrom string
+var strictDeps bool
+var emitRunScript string
+var parallelScripts bool
+var cacheDirMode string
+var verifyBins []string
+var resolutionReportPath string
+var noCache bool
+var refresh bool
+var bunPathOverride string
+var noTypes bool
+var typesNodeVersion string
+var noRemote bool
+var watch bool
+var pinVersions bool
+var bunRuntimeArgs []string
+var envFiles []string
+var envOverride bool
+var registry string
+var frozen bool
+var dryRun bool
+var withOverride bool
+var installTimeout time.Duration
+var installRetries int
+var offline bool
+var saveWith bool
+var saveExact bool
+var strictMetadata bool
+var installWarningPatterns []string
+
+var defaultInstallWarningPatterns = []string{
+	`(?i)\bwarn(ing)?\b`,
+	`(?i)\bdeprecated\b`,
+	`(?i)\bpeer dependenc`,
+}
 
 const packageJSONTemplate = `{
   "name": "bunv-temp",
   "version": "1.0.0",
   "dependencies": {
 		%s
-  }
+  }%s
 }`
 
-func getCacheDir(hash string) string {
+// getCacheRoot returns $BUNV_CACHE_DIR, else ~/.bunv/cache, else a dir under the temp dir.
+func getCacheRoot() string {
+	if dir := os.Getenv("BUNV_CACHE_DIR"); dir != "" {
+		return dir
+	}
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
-		return filepath.Join(os.TempDir(), "bunv-cache", hash)
+		return filepath.Join(os.TempDir(), "bunv-cache")
+	}
+	return filepath.Join(homeDir, ".bunv", "cache")
+}
+
+func getCacheDir(hash string) string {
+	return filepath.Join(getCacheRoot(), hash)
+}
+
+// Permissions for cache directories and files, set by --cache-dir-mode.
+var (
+	cacheDirPerm  os.FileMode = 0755
+	cacheFilePerm os.FileMode = 0644
+)
+
+// parseCacheDirMode parses an octal directory mode and returns it with the matching file mode.
+func parseCacheDirMode(mode string) (os.FileMode, os.FileMode, error) {
+	bits, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || bits > 0777 {
+		return 0, 0, fmt.Errorf("%q is not an octal permission mode", mode)
+	}
+	dirPerm := os.FileMode(bits)
+	if dirPerm&0700 != 0700 {
+		return 0, 0, fmt.Errorf("%q must give the owner read, write and execute access", mode)
+	}
+	return dirPerm, dirPerm &^ 0111, nil
+}
+
+var rootCmd = &cobra.Command{
+	Use:   "bunv",
+	Short: "Run TypeScript files with Bun and temporary dependencies",
+}
+
+type Dependencies map[string]string
+
+// HashString returns the cache key for d.
+func (d Dependencies) HashString() string {
+	depList := []string{}
+	for k, v := range d {
+		depList = append(depList, fmt.Sprintf("%s@%s", k, v))
+	}
+	sort.Strings(depList)
+	s := strings.Join(depList, ",")
+	hasher := sha256.New()
+	hasher.Write([]byte(s))
+	return fmt.Sprintf("%x", hasher.Sum(nil))[:16]
+}
+
+// CacheKey returns the cache entry name for d with the given bun version, registry and aliases.
+func (d Dependencies) CacheKey(bunVersion, registry string, aliases map[string]string) string {
+	if bunVersion == "" && registry == "" && len(aliases) == 0 {
+		return d.HashString()
+	}
+	// Parentheses can't appear in npm package names, so these can't clash
+	// with a real dependency.
+	keyed := Dependencies{}
+	if bunVersion != "" {
+		keyed["(bun)"] = bunVersion
+	}
+	if registry != "" {
+		keyed["(registry)"] = registry
+	}
+	for name, path := range aliases {
+		keyed["(alias)"+name] = path
+	}
+	for k, v := range d {
+		keyed[k] = v
+	}
+	return keyed.HashString()
+}
+
+// TypesOnly returns the subset of d made up of @types/* packages.
+func (d Dependencies) TypesOnly() Dependencies {
+	types := Dependencies{}
+	for k, v := range d {
+		if strings.HasPrefix(k, "@types/") {
+			types[k] = v
+		}
+	}
+	return types
+}
+
+// defaultEngine is the runtime scripts are run with.
+const defaultEngine = "bun"
+
+// engineWantsNodeTypes reports whether @types/node is injected for the engine.
+func engineWantsNodeTypes(engine string) bool {
+	switch engine {
+	case "bun", "node":
+		return true
+	default:
+		return false
+	}
+}
+
+// nodeTypesVersion returns the @types/node version to inject for scriptFile, or "" for none.
+func nodeTypesVersion(scriptFile string) string {
+	if noTypes {
+		return ""
+	}
+	if typesNodeVersion != "" {
+		return typesNodeVersion
+	}
+	header, _ := extractHeader(scriptFile)
+	switch v := header["typesNode"].(type) {
+	case string:
+		return v
+	case bool:
+		if !v {
+			return ""
+		}
+		return "latest"
+	}
+	if globalConfig.Types != nil && !*globalConfig.Types {
+		return ""
+	}
+	return "latest"
+}
+
+// Union returns the dependencies of d combined with other.
+func (d Dependencies) Union(other Dependencies) (Dependencies, []string) {
+	merged := Dependencies{}
+	for k, v := range other {
+		merged[k] = v
+	}
+	var conflicts []string
+	for k, v := range d {
+		if ov, ok := other[k]; ok && ov != v {
+			conflicts = append(conflicts, k)
+		}
+		merged[k] = v
+	}
+	sort.Strings(conflicts)
+	return merged, conflicts
+}
+
+// Blocked returns the first package matching one of the glob patterns, and that pattern.
+func (d Dependencies) Blocked(patterns []string) (string, string) {
+	names := make([]string, 0, len(d))
+	for k := range d {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, pattern := range patterns {
+			if ok, _ := path.Match(pattern, name); ok {
+				return name, pattern
+			}
+		}
+	}
+	return "", ""
+}
+
+// readBlocklist reads package name globs from a file, one per line.
+func readBlocklist(blocklistPath string) ([]string, error) {
+	data, err := os.ReadFile(blocklistPath)
+	if err != nil {
+		return nil, err
+	}
+	var patterns []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if _, err := path.Match(line, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %v", line, err)
+		}
+		patterns = append(patterns, line)
+	}
+	return patterns, nil
+}
+
+// parsePackageSpec splits a spec such as "@scope/pkg@2" into its name and version.
+func parsePackageSpec(spec string) (string, string) {
+	if name := gitSpecName(spec); name != "" {
+		return name, spec
+	}
+	start := 0
+	if strings.HasPrefix(spec, "@") {
+		start = 1
+	}
+	at := strings.Index(spec[start:], "@")
+	if at < 0 {
+		return strings.ToLower(spec), ""
+	}
+	return strings.ToLower(spec[:start+at]), spec[start+at+1:]
+}
+
+// resolveLocalSpec rewrites a local path version to "file:" and an absolute path under baseDir.
+func resolveLocalSpec(version, baseDir string) string {
+	localPath, ok := strings.CutPrefix(version, "file:")
+	if !ok {
+		for _, prefix := range localPathPrefixes {
+			if strings.HasPrefix(version, prefix) {
+				localPath, ok = version, true
+			}
+		}
+	}
+	if !ok {
+		return version
+	}
+	if rest, ok := strings.CutPrefix(localPath, "~/"); ok {
+		if homeDir, err := os.UserHomeDir(); err == nil {
+			localPath = filepath.Join(homeDir, rest)
+		}
+	}
+	if !filepath.IsAbs(localPath) {
+		localPath = filepath.Join(baseDir, localPath)
+	}
+	if abs, err := filepath.Abs(localPath); err == nil {
+		localPath = abs
+	}
+	return "file:" + localPath
+}
+
+// gitProtocols are the prefixes of the git specs bun installs from.
+var gitProtocols = []string{"git+", "git:", "github:", "gitlab:", "bitbucket:"}
+
+// gitSpecName returns the repository name of a git spec, or "" if spec isn't one.
+func gitSpecName(spec string) string {
+	isGit := false
+	for _, protocol := range gitProtocols {
+		if strings.HasPrefix(spec, protocol) {
+			isGit = true
+		}
+	}
+	if !isGit {
+		return ""
+	}
+	repo, _, _ := strings.Cut(spec, "#")
+	repo = strings.TrimRight(repo, "/")
+	repo = repo[strings.LastIndexAny(repo, "/:")+1:]
+	return strings.ToLower(strings.TrimSuffix(repo, ".git"))
+}
+
+// specNameChanged reports whether parsePackageSpec lowercased the name in spec.
+func specNameChanged(spec, name string) bool {
+	return !strings.HasPrefix(spec, name) && strings.HasPrefix(strings.ToLower(spec), name)
+}
+
+// normalizeDependencyNames lowercases the package names in a header section, dropping duplicates.
+func normalizeDependencyNames(deps map[string]any, section, scriptFile string) {
+	names := make([]string, 0, len(deps))
+	for name := range deps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		lower := strings.ToLower(name)
+		if lower == name {
+			continue
+		}
+		if _, ok := deps[lower]; ok {
+			fmt.Fprintf(os.Stderr, "Warning: Removing %s from %s of %s, which duplicates %s\n", name, section, scriptFile, lower)
+		} else {
+			fmt.Fprintf(os.Stderr, "Warning: Renaming %s to %s in %s of %s, since npm package names are lowercase\n", name, lower, section, scriptFile)
+			deps[lower] = deps[name]
+		}
+		delete(deps, name)
+	}
+}
+
+// dependencySource describes where a resolved dependency was requested.
+func dependencySource(name, scriptFile string) string {
+	fromWith := false
+	for _, pkg := range withPackages {
+		if pkgName, _ := parsePackageSpec(strings.TrimSpace(pkg)); pkgName == name {
+			fromWith = true
+		}
+	}
+	if fromWith && withOverride {
+		return "--with"
+	}
+	if headerDeps, _ := extractDependenciesFromHeader(scriptFile); headerDeps != nil {
+		if _, ok := headerDeps[name]; ok {
+			return scriptFile
+		}
+	}
+	if devNames := devDependencyNames(scriptFile); devNames[name] {
+		return scriptFile + " (devDependencies)"
+	}
+	if fromWith {
+		return "--with"
+	}
+	for _, pkg := range globalConfig.With {
+		if pkgName, _ := parsePackageSpec(strings.TrimSpace(pkg)); pkgName == name {
+			return configPath()
+		}
+	}
+	if dedupeWith != "" {
+		if otherDeps, _ := extractDependenciesFromHeader(dedupeWith); otherDeps != nil {
+			if _, ok := otherDeps[name]; ok {
+				return dedupeWith
+			}
+		}
+	}
+	return "bunv (implicit)"
+}
+
+// getDependencies resolves the dependencies for scriptFile.
+func getDependencies(scriptFile, engine string, bases ...string) Dependencies {
+	return resolveDependencies(scriptFile, engine, nil, bases...)
+}
+
+// resolveDependencies is getDependencies, recording every proposed version in trace.
+func resolveDependencies(scriptFile, engine string, trace dependencyTrace, bases ...string) Dependencies {
+	headerDeps, _ := extractDependenciesFromHeader(scriptFile)
+	mergedDeps := map[string]string{}
+	propose := func(name, source, version string) {
+		// An empty version would otherwise go into the cache key as is
+		if strings.TrimSpace(version) == "" {
+			fmt.Fprintf(os.Stderr, "Warning: %s: %s has an empty version, using latest\n", source, name)
+			version = "latest"
+		} else if err := checkVersionSpec(version); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %s: %s: %v\n", source, name, err)
+		}
+		mergedDeps[name] = version
+		trace.add(name, source, version)
+	}
+	if engineWantsNodeTypes(engine) {
+		if version := nodeTypesVersion(scriptFile); version != "" {
+			propose("@types/node", "bunv (implicit)", version)
+		}
+	}
+	for _, base := range bases {
+		baseDeps, _ := extractDependenciesFromHeader(base)
+		for k, v := range baseDeps {
+			propose(k, base, resolveLocalSpec(v, filepath.Dir(base)))
+		}
+	}
+	// Local paths on the command line or in the config are relative to the
+	// working directory
+	proposeSpecs := func(specs []string, source string) {
+		for _, pkg := range specs {
+			pkg = strings.TrimSpace(pkg)
+			if pkg != "" {
+				depName, depVer := parsePackageSpec(pkg)
+				if specNameChanged(pkg, depName) {
+					fmt.Fprintf(os.Stderr, "Warning: %s: using %s for %s, since npm package names are lowercase\n", source, depName, pkg)
+				}
+				if depVer == "" && !strings.HasSuffix(pkg, "@") {
+					depVer = "latest"
+				}
+				propose(depName, source, resolveLocalSpec(depVer, "."))
+			}
+		}
+	}
+	proposeSpecs(globalConfig.With, configPath())
+	if !withOverride {
+		proposeSpecs(withPackages, "--with")
+	}
+	// Dev dependencies are installed too; "dependencies" wins where a
+	// package is in both
+	devDeps, _ := extractDevDependenciesFromHeader(scriptFile)
+	scriptDir := filepath.Dir(scriptFile)
+	for k, v := range devDeps {
+		propose(k, scriptFile+" (devDependencies)", resolveLocalSpec(v, scriptDir))
+	}
+	for k, v := range headerDeps {
+		propose(k, scriptFile, resolveLocalSpec(v, scriptDir))
+	}
+	if withOverride {
+		proposeSpecs(withPackages, "--with")
+	}
+	for _, pkg := range withPackages {
+		name, version := parsePackageSpec(strings.TrimSpace(pkg))
+		if version == "" {
+			version = "latest"
+		}
+		if headerVersion, ok := headerDeps[name]; ok && resolveLocalSpec(headerVersion, scriptDir) != resolveLocalSpec(version, ".") {
+			chosen := fmt.Sprintf("%s from the header (pass --with-override to use --with)", headerVersion)
+			if withOverride {
+				chosen = version + " from --with"
+			}
+			fmt.Fprintf(os.Stderr, "Warning: --with %s@%s conflicts with %s@%s in %s; using %s\n",
+				name, version, name, headerVersion, scriptFile, chosen)
+		}
+	}
+	return Dependencies(mergedDeps)
+}
+
+// installArgv returns the bun arguments for the install step in cacheDir.
+func installArgv(cacheDir string) []string {
+	argv := []string{"install"}
+	if (deterministic || frozen || runMode == "production") && findLockfile(cacheDir) != "" {
+		argv = append(argv, "--frozen-lockfile")
+	}
+	// Each value was checked by splitArgs before the run
+	for _, a := range installArgs {
+		args, _ := splitArgs(a)
+		argv = append(argv, args...)
+	}
+	return argv
+}
+
+// runInstall runs bun install once in cacheDir, collecting its output in output.
+func runInstall(bunPath, cacheDir string, output *bytes.Buffer) (argv []string, truncated bool, err error) {
+	ctx := context.Background()
+	if installTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, installTimeout)
+		defer cancel()
+	}
+	installCmd := exec.CommandContext(ctx, bunPath, installArgv(cacheDir)...)
+	installCmd.Dir = cacheDir
+	// Don't wait forever on pipes held open by processes bun started
+	installCmd.WaitDelay = 5 * time.Second
+	if bunInstallCache != "" {
+		installCmd.Env = setEnv(os.Environ(), "BUN_INSTALL_CACHE_DIR", bunInstallCache)
+	}
+	var installLog io.Writer = os.Stderr
+	var limitedLog *lineLimitWriter
+	if quiet {
+		installLog = io.Discard
+	} else if maxInstallOutputLines > 0 {
+		limitedLog = &lineLimitWriter{w: os.Stderr, max: maxInstallOutputLines}
+		installLog = limitedLog
+	}
+	debugf("running %s in %s\n", shellQuote(installCmd.Args), cacheDir)
+	installCmd.Stdout = io.MultiWriter(installLog, output)
+	installCmd.Stderr = installCmd.Stdout
+	err = installCmd.Run()
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("timed out after %s (see --install-timeout)", installTimeout)
+	}
+	return installCmd.Args, limitedLog != nil && limitedLog.Truncated(), err
+}
+
+var exactVersionRe = regexp.MustCompile(`^v?\d+\.\d+\.\d+(-[0-9A-Za-z.-]+)?(\+[0-9A-Za-z.-]+)?$`)
+
+// Loose returns the sorted names of packages not pinned to an exact version.
+func (d Dependencies) Loose() []string {
+	var loose []string
+	for k, v := range d {
+		if !exactVersionRe.MatchString(v) {
+			loose = append(loose, k)
+		}
+	}
+	sort.Strings(loose)
+	return loose
+}
+
+// siblingLockfile returns the lockfile saved next to scriptFile by --frozen, or "".
+func siblingLockfile(scriptFile string) string {
+	for _, name := range lockfileNames {
+		path := scriptFile + strings.TrimPrefix(name, "bun")
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// restoreLockfile copies saved into cacheDir and reports whether the cache's lockfile differed.
+func restoreLockfile(saved, cacheDir string) (bool, error) {
+	want, err := os.ReadFile(saved)
+	if err != nil {
+		return false, err
+	}
+	name := "bun.lock"
+	if strings.HasSuffix(saved, ".lockb") {
+		name = "bun.lockb"
+	}
+	if existing := findLockfile(cacheDir); existing != "" {
+		if have, err := os.ReadFile(existing); err == nil && filepath.Base(existing) == name && bytes.Equal(have, want) {
+			return false, nil
+		}
+		if err := os.Remove(existing); err != nil {
+			return false, err
+		}
+	}
+	return true, os.WriteFile(filepath.Join(cacheDir, name), want, cacheFilePerm)
+}
+
+// saveLockfile copies cacheDir's lockfile next to scriptFile and returns where it was saved.
+func saveLockfile(scriptFile, cacheDir string) (string, error) {
+	lockfile := findLockfile(cacheDir)
+	if lockfile == "" {
+		return "", fmt.Errorf("bun install did not write a lockfile")
+	}
+	saved := scriptFile + strings.TrimPrefix(filepath.Base(lockfile), "bun")
+	return saved, copyFile(lockfile, saved, 0644)
+}
+
+// findLockfile returns the path of the lockfile in dir, or "" if none exists.
+func findLockfile(dir string) string {
+	for _, name := range lockfileNames {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// checkDeterministic rejects loose versions in deps unless cacheDir has a lockfile.
+func checkDeterministic(deps Dependencies, cacheDir string) error {
+	loose := deps.Loose()
+	if len(loose) == 0 || findLockfile(cacheDir) != "" {
+		return nil
+	}
+	pins := make([]string, len(loose))
+	for i, name := range loose {
+		pins[i] = name + "@" + deps[name]
+	}
+	return fmt.Errorf("--deterministic requires exact versions or an existing lockfile; loose versions: %s", strings.Join(pins, ", "))
+}
+
+// lineLimitWriter passes through the first max lines written to it and drops the rest.
+type lineLimitWriter struct {
+	w         io.Writer
+	max       int
+	lines     int
+	truncated bool
+}
+
+func (l *lineLimitWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 && l.lines < l.max {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			_, err := l.w.Write(p)
+			return n, err
+		}
+		if _, err := l.w.Write(p[:i+1]); err != nil {
+			return n, err
+		}
+		l.lines++
+		p = p[i+1:]
+	}
+	if len(p) > 0 {
+		l.truncated = true
+	}
+	return n, nil
+}
+
+// Truncated reports whether any output was dropped.
+func (l *lineLimitWriter) Truncated() bool {
+	return l.truncated
+}
+
+// Lines of install output repeated when bun install fails.
+const installFailureTail = 20
+
+// lastLines returns the last n non-blank lines of output.
+func lastLines(output string, n int) []string {
+	var lines []string
+	for _, line := range strings.Split(output, "\n") {
+		if strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines
+}
+
+// installedVersion returns the version of the package installed under nodeModules.
+func installedVersion(nodeModules, name string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(nodeModules, name, "package.json"))
+	if err != nil {
+		return "", err
+	}
+	var manifest struct {
+		Version string `json:"version"`
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return "", err
+	}
+	return manifest.Version, nil
+}
+
+// pinDependencies pins scriptFile's dependencies to the versions installed under nodeModules.
+func pinDependencies(scriptFile, nodeModules string) ([]string, error) {
+	origBytes, err := os.ReadFile(scriptFile)
+	if err != nil {
+		return nil, err
+	}
+	header, before, after, found := parseMetadataBlock(string(origBytes))
+	deps, _ := header["dependencies"].(map[string]any)
+	if !found || len(deps) == 0 {
+		return nil, nil
+	}
+
+	var changes []string
+	for name, spec := range deps {
+		// A git, file or aliased spec says where to install from, which a
+		// bare version would lose
+		if specString, _ := spec.(string); hasVersionProtocol(specString) {
+			continue
+		}
+		installed, err := installedVersion(nodeModules, name)
+		if err != nil || installed == "" || spec == installed {
+			continue
+		}
+		changes = append(changes, fmt.Sprintf("%s: %v -> %s", name, spec, installed))
+		deps[name] = installed
+	}
+	if len(changes) == 0 {
+		return nil, nil
+	}
+	sort.Strings(changes)
+
+	newBlock, err := renderMetadataBlock(header, string(origBytes))
+	if err != nil {
+		return nil, err
+	}
+	return changes, os.WriteFile(scriptFile, []byte(before+newBlock+after), 0644)
+}
+
+// saveWithPackages adds the --with packages to scriptFile's metadata block.
+func saveWithPackages(scriptFile, nodeModules string, exact bool) ([]string, error) {
+	origBytes, err := os.ReadFile(scriptFile)
+	if err != nil {
+		return nil, err
+	}
+	header, before, after, found := parseMetadataBlock(string(origBytes))
+	deps, _ := header["dependencies"].(map[string]any)
+	if deps == nil {
+		deps = map[string]any{}
+	}
+
+	var saved []string
+	for _, pkg := range withPackages {
+		pkg = strings.TrimSpace(pkg)
+		if pkg == "" {
+			continue
+		}
+		name, version := parsePackageSpec(pkg)
+		if version == "" {
+			version = "latest"
+		}
+		if exact && !hasVersionProtocol(version) {
+			if installed, err := installedVersion(nodeModules, name); err == nil && installed != "" {
+				version = installed
+			}
+		}
+		deps[name] = version
+		saved = append(saved, name+"@"+version)
+	}
+	if len(saved) == 0 {
+		return nil, nil
+	}
+	header["dependencies"] = deps
+
+	newBlock, err := renderMetadataBlock(header, string(origBytes))
+	if err != nil {
+		return nil, err
+	}
+	newContent := before + newBlock + after
+	if !found {
+		newContent = insertBlock(after, newBlock, shebangLines(after))
+	}
+	return saved, os.WriteFile(scriptFile, []byte(newContent), 0644)
+}
+
+// installedMismatches lists installed packages outside their declared range.
+func installedMismatches(deps Dependencies, nodeModules string) []string {
+	names := make([]string, 0, len(deps))
+	for k := range deps {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var mismatches []string
+	for _, name := range names {
+		spec := deps[name]
+		r, err := parseRange(spec)
+		if err != nil {
+			continue
+		}
+		installed, err := installedVersion(nodeModules, name)
+		if err != nil {
+			mismatches = append(mismatches, fmt.Sprintf("%s is not installed", name))
+			continue
+		}
+		v, err := parseVersion(installed)
+		if err != nil || !r.Matches(v) {
+			mismatches = append(mismatches, fmt.Sprintf("installed %s@%s does not satisfy %s", name, installed, spec))
+		}
+	}
+	return mismatches
+}
+
+// uninstalledPackages returns the sorted names of packages in deps missing from nodeModules.
+func uninstalledPackages(deps Dependencies, nodeModules string) []string {
+	var missing []string
+	for name := range deps {
+		if _, err := os.Stat(filepath.Join(nodeModules, name, "package.json")); err != nil {
+			missing = append(missing, name)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// missingBins returns the names in bins with no entry in nodeModules/.bin.
+func missingBins(nodeModules string, bins []string) []string {
+	var missing []string
+	for _, bin := range bins {
+		path := filepath.Join(nodeModules, ".bin", bin)
+		if _, err := os.Stat(path); err == nil {
+			continue
+		}
+		if _, err := os.Stat(path + ".cmd"); err == nil {
+			continue
+		}
+		missing = append(missing, bin)
+	}
+	return missing
+}
+
+// treeHash hashes the dependency map together with the lockfile in cacheDir.
+func treeHash(deps Dependencies, cacheDir string) string {
+	names := make([]string, 0, len(deps))
+	for k := range deps {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	hasher := sha256.New()
+	for _, name := range names {
+		fmt.Fprintf(hasher, "%s@%s\n", name, deps[name])
+	}
+	if lockfile := findLockfile(cacheDir); lockfile != "" {
+		if data, err := os.ReadFile(lockfile); err == nil {
+			hasher.Write([]byte{0})
+			hasher.Write(data)
+		}
+	}
+	return fmt.Sprintf("%x", hasher.Sum(nil))[:16]
+}
+
+// findInstallWarning returns the first line of output matching one of patterns.
+func findInstallWarning(output string, patterns []string) (string, error) {
+	res := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return "", err
+		}
+		res = append(res, re)
+	}
+	for _, line := range strings.Split(output, "\n") {
+		for _, re := range res {
+			if re.MatchString(line) {
+				return strings.TrimSpace(line), nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// readScriptFromStdin saves stdin to a uniquely named script in root and returns its path.
+func readScriptFromStdin(root string) (string, error) {
+	if err := os.MkdirAll(root, cacheDirPerm); err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp(root, ".stdin-*.ts")
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(f, os.Stdin); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), f.Close()
+}
+
+// File types bun runs that can carry a metadata block.
+var scriptExtensions = []string{".ts", ".tsx", ".mts", ".cts", ".js", ".jsx", ".mjs", ".cjs"}
+
+// isScriptFile reports whether path has one of scriptExtensions, or no extension.
+func isScriptFile(path string) bool {
+	ext := filepath.Ext(path)
+	return ext == "" || slices.Contains(scriptExtensions, ext)
+}
+
+var versionedScriptRe = regexp.MustCompile(`\.v(\d+)\.(?:ts|tsx|mts|cts|js|jsx|mjs|cjs)$`)
+
+// resolveScriptPath maps 'name@latest' or 'name@N' to the matching 'name.vN.ts' file.
+func resolveScriptPath(arg string) (string, error) {
+	if _, err := os.Stat(arg); err == nil {
+		return arg, nil
+	}
+	at := strings.LastIndex(arg, "@")
+	if at <= 0 {
+		return arg, nil
+	}
+	name, want := arg[:at], arg[at+1:]
+	wantVersion := -1
+	if want != "latest" {
+		n, err := strconv.Atoi(want)
+		if err != nil {
+			return arg, nil
+		}
+		wantVersion = n
+	}
+
+	candidates, err := filepath.Glob(name + ".v*.*")
+	if err != nil {
+		return "", err
+	}
+	best, bestVersion := "", -1
+	for _, c := range candidates {
+		m := versionedScriptRe.FindStringSubmatch(c)
+		if m == nil || strings.TrimSuffix(c, m[0]) != name {
+			continue
+		}
+		v, _ := strconv.Atoi(m[1])
+		if (wantVersion < 0 && v > bestVersion) || v == wantVersion {
+			best, bestVersion = c, v
+		}
+	}
+	if best == "" {
+		return "", fmt.Errorf("no script matching %s.v*.ts for %s", name, arg)
+	}
+	return best, nil
+}
+
+// getAliases returns the script's module aliases from its header and --alias flags.
+func getAliases(scriptFile, scriptDir string) (map[string]string, error) {
+	header, _ := extractHeader(scriptFile)
+	aliases := headerStringMap(header, "aliases")
+	for _, a := range aliasFlags {
+		name, path, ok := strings.Cut(a, "=")
+		if !ok || name == "" || path == "" {
+			return nil, fmt.Errorf("invalid --alias %q, expected name=path", a)
+		}
+		aliases[name] = path
+	}
+	for name, path := range aliases {
+		if !filepath.IsAbs(path) {
+			aliases[name] = filepath.Join(scriptDir, path)
+		}
+	}
+	return aliases, nil
+}
+
+// scriptAliases returns the module aliases of scriptFile as 'bunv run' would find them.
+func scriptAliases(scriptFile string) (map[string]string, error) {
+	absScriptPath, err := filepath.Abs(scriptFile)
+	if err != nil {
+		return nil, err
+	}
+	return getAliases(scriptFile, filepath.Dir(absScriptPath))
+}
+
+// writeAliasConfig writes a tsconfig.json into cacheDir mapping each alias to its path.
+func writeAliasConfig(cacheDir string, aliases map[string]string) error {
+	configPath := filepath.Join(cacheDir, "tsconfig.json")
+	if len(aliases) == 0 {
+		if err := os.Remove(configPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	paths := map[string][]string{}
+	for name, path := range aliases {
+		paths[name] = []string{path}
+		paths[name+"/*"] = []string{path + "/*"}
+	}
+	config := map[string]any{
+		"compilerOptions": map[string]any{
+			"baseUrl": ".",
+			"paths":   paths,
+		},
+	}
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if existing, err := os.ReadFile(configPath); err == nil && bytes.Equal(existing, data) {
+		return nil
+	}
+	f, err := os.CreateTemp(cacheDir, ".tsconfig-*.json")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), cacheFilePerm)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), configPath)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// printPlan describes the install bunv is about to perform for deps.
+func printPlan(w io.Writer, deps Dependencies, cacheDir string, cold bool) {
+	fmt.Fprintf(w, "Install plan:\n")
+	names := make([]string, 0, len(deps))
+	for k := range deps {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %s@%s\n", name, deps[name])
+	}
+	fmt.Fprintf(w, "Cache directory: %s\n", cacheDir)
+	if cold {
+		fmt.Fprintf(w, "Cache: cold (new install)\n")
+		fmt.Fprintf(w, "Command: bun %s\n", strings.Join(installArgv(cacheDir), " "))
+	} else {
+		fmt.Fprintf(w, "Cache: warm (reusing existing install)\n")
+	}
+}
+
+// isInteractive reports whether stdin is a terminal.
+func isInteractive() bool {
+	return term.IsTerminal(int(os.Stdin.Fd()))
+}
+
+// confirm asks a yes/no question on stderr and reads the answer from stdin.
+func confirm(question string) bool {
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// Exit status when bun isn't installed, as for a command the shell can't find.
+const exitBunNotFound = 127
+
+// ensureBun returns the absolute path of the bun executable, or an error explaining how to install it.
+func ensureBun() (string, error) {
+	override, source := bunPathOverride, "--bun-path"
+	if override == "" {
+		override, source = os.Getenv("BUNV_BUN"), "BUNV_BUN"
+	}
+	if override == "" {
+		override, source = globalConfig.BunPath, "bun-path in "+configPath()
+	}
+	if override != "" {
+		bunPath, err := exec.LookPath(override)
+		if err == nil {
+			bunPath, err = filepath.Abs(bunPath)
+		}
+		if err != nil {
+			return "", fmt.Errorf("%s %s is not an executable: %v", source, override, err)
+		}
+		return bunPath, nil
+	}
+
+	bunPath, err := exec.LookPath("bun")
+	if err != nil {
+		return "", fmt.Errorf("bun was not found on your PATH. bunv runs scripts with Bun; install it from https://bun.sh with:\n\n    curl -fsSL https://bun.sh/install | bash\n")
+	}
+	return bunPath, nil
+}
+
+// minBunVersion returns --min-bun, else the header's "bun.minVersion".
+func minBunVersion(scriptFile string) string {
+	if minBun != "" {
+		return minBun
+	}
+	header, _ := extractHeader(scriptFile)
+	if bunSettings, ok := header["bun"].(map[string]any); ok {
+		if v, ok := bunSettings["minVersion"].(string); ok {
+			return v
+		}
+	}
+	return ""
+}
+
+// scriptRegistry returns the registry to install scriptFile's dependencies from, or "" for bun's default.
+func scriptRegistry(scriptFile string) (string, error) {
+	reg := registry
+	if reg == "" {
+		header, _ := extractHeader(scriptFile)
+		reg, _ = header["registry"].(string)
+	}
+	if reg == "" {
+		reg = globalConfig.Registry
+	}
+	if reg == "" {
+		return "", nil
+	}
+	u, err := url.Parse(reg)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("invalid registry URL %q", reg)
+	}
+	return reg, nil
+}
+
+// writeBunfig writes a bunfig.toml into cacheDir that points bun install at registry.
+func writeBunfig(cacheDir, registry string) error {
+	content := fmt.Sprintf("[install]\nregistry = %q\n", registry)
+	return os.WriteFile(filepath.Join(cacheDir, "bunfig.toml"), []byte(content), cacheFilePerm)
+}
+
+// runArgv returns the bun arguments to run scriptFile from scriptPath.
+func runArgv(scriptFile, scriptPath string, scriptArgs []string) []string {
+	argv := []string{"run"}
+	if runMode != "" {
+		argv = append(argv, "--conditions="+runMode)
+	}
+	argv = append(argv, runtimeArgs(scriptFile)...)
+	argv = append(argv, scriptPath)
+	return append(argv, scriptArgs...)
+}
+
+// runtimeArgs returns the header's "bunArgs" followed by any --bun-arg flags.
+func runtimeArgs(scriptFile string) []string {
+	var args []string
+	header, _ := extractHeader(scriptFile)
+	list, _ := header["bunArgs"].([]any)
+	for _, v := range list {
+		arg, ok := v.(string)
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Warning: Ignoring non-string bunArgs entry %v\n", v)
+			continue
+		}
+		args = append(args, arg)
+	}
+	return append(args, bunRuntimeArgs...)
+}
+
+// checkBunVersion returns an error if the bun at bunPath is older than minVersion.
+func checkBunVersion(bunPath, minVersion string) error {
+	want, err := parseVersion(minVersion)
+	if err != nil {
+		return fmt.Errorf("invalid minimum bun version: %v", err)
+	}
+	installed := getBunVersion(bunPath)
+	have, err := parseVersion(installed)
+	if err != nil {
+		return fmt.Errorf("could not determine bun version: %v", err)
+	}
+	if compareVersions(have, want) < 0 {
+		return fmt.Errorf("this script requires bun %s or newer, but bun %s is installed; upgrade with 'bun upgrade'", minVersion, installed)
+	}
+	return nil
+}
+
+// headerScriptCommand returns the sh command and env that run the named header script, npm-style.
+func headerScriptCommand(scriptFile, name string, extraArgs []string, cacheDir string, env []string) (string, []string, []string, error) {
+	scripts, _ := extractScriptsFromHeader(scriptFile)
+	command, ok := scripts[name]
+	if !ok {
+		return "", nil, nil, fmt.Errorf("script %q is not defined in %s", name, scriptFile)
+	}
+
+	binDir := filepath.Join(cacheDir, "node_modules", ".bin")
+	pathSet := false
+	for i, v := range env {
+		if strings.HasPrefix(v, "PATH=") {
+			env[i] = fmt.Sprintf("PATH=%s%c%s", binDir, os.PathListSeparator, v[len("PATH="):])
+			pathSet = true
+			break
+		}
+	}
+	if !pathSet {
+		env = append(env, "PATH="+binDir)
+	}
+
+	shPath, err := exec.LookPath("sh")
+	if err != nil {
+		return "", nil, nil, err
+	}
+	return shPath, append([]string{"-c", command + ` "$@"`, "sh"}, extraArgs...), env, nil
+}
+
+// sameFile reports whether a and b name the same existing file.
+func sameFile(a, b string) bool {
+	ai, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	bi, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(ai, bi)
+}
+
+// setEnv returns env with key set to value, replacing any existing entry.
+func setEnv(env []string, key, value string) []string {
+	for i, v := range env {
+		if strings.HasPrefix(v, key+"=") {
+			env[i] = key + "=" + value
+			return env
+		}
+	}
+	return append(env, key+"="+value)
+}
+
+// missingTypesRe matches tsc's diagnostic for a module without type declarations.
+var missingTypesRe = regexp.MustCompile(`error TS7016: Could not find a declaration file for module '([^']+)'`)
+
+// tscDiagnosticRe matches any diagnostic tsc reports.
+var tscDiagnosticRe = regexp.MustCompile(`error TS\d+:`)
+
+// findMissingTypes typechecks the script with tsc and returns the modules lacking types.
+func findMissingTypes(bunPath, cacheDir, scriptPath string) ([]string, error) {
+	// The npm package named tsc is not the TypeScript compiler
+	tscCmd := exec.Command(bunPath, "x", "--package", "typescript", "tsc", "--noEmit", "--noImplicitAny", "--skipLibCheck",
+		"--module", "esnext", "--moduleResolution", "bundler", "--target", "esnext", scriptPath)
+	tscCmd.Dir = cacheDir
+	output, err := tscCmd.CombinedOutput()
+	if _, ok := err.(*exec.ExitError); err != nil && !ok {
+		return nil, err
+	}
+	return parseMissingTypes(string(output), err != nil)
+}
+
+// parseMissingTypes extracts the modules lacking type declarations from tsc's output.
+func parseMissingTypes(output string, failed bool) ([]string, error) {
+	if failed && !tscDiagnosticRe.MatchString(output) {
+		return nil, fmt.Errorf("tsc failed: %s", strings.TrimSpace(output))
+	}
+	seen := map[string]bool{}
+	var missing []string
+	for _, m := range missingTypesRe.FindAllStringSubmatch(output, -1) {
+		if !seen[m[1]] {
+			seen[m[1]] = true
+			missing = append(missing, m[1])
+		}
+	}
+	sort.Strings(missing)
+	return missing, nil
+}
+
+// splitArgs splits s into arguments the way a POSIX shell does for simple words.
+func splitArgs(s string) ([]string, error) {
+	var args []string
+	var cur strings.Builder
+	inArg := false
+	var quote rune
+	escaped := false
+	for _, r := range s {
+		switch {
+		case escaped:
+			cur.WriteRune(r)
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				cur.WriteRune(r)
+			}
+		case r == '\\':
+			escaped = true
+			inArg = true
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else {
+				cur.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inArg {
+				args = append(args, cur.String())
+				cur.Reset()
+				inArg = false
+			}
+		default:
+			cur.WriteRune(r)
+			inArg = true
+		}
+	}
+	if escaped || quote != 0 {
+		return nil, fmt.Errorf("unterminated quote or escape")
+	}
+	if inArg {
+		args = append(args, cur.String())
+	}
+	return args, nil
+}
+
+// runCacheHook runs an --on-hit/--on-miss command, warning if it fails.
+func runCacheHook(hook, depHash, cacheDir, scriptFile string) {
+	if hook == "" {
+		return
+	}
+	hookCmd := exec.Command("sh", "-c", hook, "sh", depHash, scriptFile)
+	hookCmd.Env = append(os.Environ(),
+		"BUNV_CACHE_HASH="+depHash,
+		"BUNV_CACHE_DIR="+cacheDir,
+		"BUNV_SCRIPT="+scriptFile,
+	)
+	hookCmd.Stdout = os.Stderr
+	hookCmd.Stderr = os.Stderr
+	if err := hookCmd.Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: Cache hook %q failed: %v\n", hook, err)
+	}
+}
+
+// relayedSignal is set once runBun has passed a signal on to bun.
+var relayedSignal atomic.Bool
+
+// runBun runs bun as a child process, relaying SIGINT and SIGTERM to it.
+func runBun(bunPath string, bunArgs, env []string, stdin *os.File) (*os.ProcessState, error) {
+	bunCmd := exec.Command(bunPath, bunArgs...)
+	bunCmd.Env = env
+	bunCmd.Stdin = stdin
+	bunCmd.Stdout = os.Stdout
+	bunCmd.Stderr = os.Stderr
+
+	// bun normally gets its own process group so that a relayed signal
+	// reaches everything it spawned. A script reading from a terminal must
+	// stay in the terminal's foreground group, though, or it would be
+	// stopped by SIGTTIN; there Ctrl-C already reaches the whole group, so
+	// only SIGTERM needs relaying.
+	ownGroup := !term.IsTerminal(int(stdin.Fd()))
+	if ownGroup {
+		setProcessGroup(bunCmd)
 	}
-	return filepath.Join(homeDir, ".bunv", "cache", hash)
-}
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	if err := bunCmd.Start(); err != nil {
+		return nil, err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case sig := <-sigs:
+				relayedSignal.Store(true)
+				if ownGroup {
+					signalProcessGroup(bunCmd.Process.Pid, sig.(syscall.Signal))
+				} else if sig != os.Interrupt {
+					bunCmd.Process.Signal(sig)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
 
-var rootCmd = &cobra.Command{
-	Use:   "bunv",
-	Short: "Run TypeScript files with Bun and temporary dependencies",
+	err := bunCmd.Wait()
+	if _, ok := err.(*exec.ExitError); ok {
+		err = nil
+	}
+	return bunCmd.ProcessState, err
 }
 
-type Dependencies map[string]string
-
-func (d Dependencies) HashString() string {
-	depList := []string{}
-	for k, v := range d {
-		depList = append(depList, fmt.Sprintf("%s@%s", k, v))
+// exitStatus returns the exit code of a finished process, and the signal that killed it.
+func exitStatus(state *os.ProcessState) (int, syscall.Signal) {
+	if state == nil {
+		return 0, 0
 	}
-	s := strings.Join(depList, ",")
-	hasher := sha256.New()
-	hasher.Write([]byte(s))
-	return fmt.Sprintf("%x", hasher.Sum(nil))[:16]
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal()), status.Signal()
+	}
+	return state.ExitCode(), 0
 }
 
-func getDependencies(scriptFile string) Dependencies {
-	headerDeps, _ := extractDependenciesFromHeader(scriptFile)
-	mergedDeps := map[string]string{"@types/node": "latest"}
-	for _, pkg := range withPackages {
-		pkg = strings.TrimSpace(pkg)
-		if pkg != "" {
-			depName := pkg
-			depVer := "latest"
-			if at := strings.LastIndex(pkg, "@"); at > 0 {
-				depName = pkg[:at]
-				depVer = pkg[at+1:]
+// copyAssets copies the files in scriptDir matching patterns into cacheDir.
+func copyAssets(scriptDir, cacheDir string, patterns []string, scriptBase string) ([]string, error) {
+	var copied []string
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(filepath.Join(scriptDir, pattern))
+		if err != nil {
+			return copied, err
+		}
+		for _, src := range matches {
+			info, err := os.Stat(src)
+			if err != nil || !info.Mode().IsRegular() {
+				continue
+			}
+			rel, err := filepath.Rel(scriptDir, src)
+			if err != nil || rel == scriptBase || strings.HasPrefix(rel, "..") {
+				continue
 			}
-			mergedDeps[depName] = depVer
+			dst := filepath.Join(cacheDir, rel)
+			if err := copyFile(src, dst, info.Mode().Perm()); err != nil {
+				return copied, err
+			}
+			copied = append(copied, dst)
 		}
 	}
-	for k, v := range headerDeps {
-		mergedDeps[k] = v
+	return copied, nil
+}
+
+func copyFile(src, dst string, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(dst), cacheDirPerm); err != nil {
+		return err
 	}
-	return Dependencies(mergedDeps)
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
 }
 
-var runCmd = &cobra.Command{
-	Use:   "run [script.ts] [-- script-args...]",
-	Short: "Run a TypeScript file with optional dependencies",
+var addCmd = &cobra.Command{
+	Use:   "add --script <script.ts> <dep[@version]>...",
+	Short: "Add dependencies to a TypeScript script's inline metadata",
 	Args:  cobra.MinimumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		scriptFile := args[0]
-		scriptArgs := args[1:]
-
+		scriptFile, _ := cmd.Flags().GetString("script")
+		if scriptFile == "" {
+			fmt.Fprintf(os.Stderr, "Error: --script flag is required\n")
+			os.Exit(1)
+		}
 		if _, err := os.Stat(scriptFile); os.IsNotExist(err) {
 			fmt.Fprintf(os.Stderr, "Error: File %s does not exist\n", scriptFile)
 			os.Exit(1)
 		}
+		if !isScriptFile(scriptFile) {
+			fmt.Fprintf(os.Stderr, "Error: %s can't hold a metadata block, since '//' comments are only valid in %s files\n", scriptFile, strings.Join(scriptExtensions, "/"))
+			os.Exit(1)
+		}
 
-		deps := getDependencies(scriptFile)
-		depHash := deps.HashString()
-		cacheDir := getCacheDir(depHash)
-		needInstall := false
+		origBytes, err := os.ReadFile(scriptFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading script file: %v\n", err)
+			os.Exit(1)
+		}
+		header, before, after, found := parseMetadataBlock(string(origBytes))
+		section := "dependencies"
+		if dev, _ := cmd.Flags().GetBool("dev"); dev {
+			section = "devDependencies"
+		}
+		deps, _ := header[section].(map[string]any)
+		if deps == nil {
+			deps = map[string]any{}
+		}
 
-		if _, err := os.Stat(cacheDir); os.IsNotExist(err) {
-			needInstall = true
-			if err := os.MkdirAll(cacheDir, 0755); err != nil {
-				fmt.Fprintf(os.Stderr, "Error creating cache directory: %v\n", err)
+		// Versions of packages named without one can come from a manifest
+		var fromVersions map[string]string
+		if fromPath, _ := cmd.Flags().GetString("from"); fromPath != "" {
+			fromVersions, err = readManifestVersions(fromPath)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error reading %s: %v\n", fromPath, err)
 				os.Exit(1)
 			}
 		}
 
-		if needInstall {
-			depEntries := []string{}
-			for k, v := range deps {
-				depEntries = append(depEntries, fmt.Sprintf("\"%s\": \"%s\"", k, v))
-			}
-			sort.Strings(depEntries)
-			packageJSON := fmt.Sprintf(packageJSONTemplate, strings.Join(depEntries, ",\n    "))
+		normalizeDependencyNames(deps, section, scriptFile)
 
-			packageJSONPath := filepath.Join(cacheDir, "package.json")
-			var prettyJSON bytes.Buffer
-			if err := json.Indent(&prettyJSON, []byte(packageJSON), "", "  "); err != nil {
-				fmt.Fprintf(os.Stderr, "Error formatting package.json as JSON: %v\n", err)
-				os.Exit(1)
+		// Parse new dependencies from args
+		for _, depArg := range args {
+			depName, depVer := parsePackageSpec(depArg)
+			if specNameChanged(depArg, depName) {
+				fmt.Fprintf(os.Stderr, "Warning: Adding %s as %s, since npm package names are lowercase\n", depArg, depName)
 			}
-			if err := os.WriteFile(packageJSONPath, prettyJSON.Bytes(), 0644); err != nil {
-				fmt.Fprintf(os.Stderr, "Error writing package.json: %v\n", err)
-				os.Exit(1)
+			if depVer == "" {
+				depVer = "latest"
+				if fromVersions != nil {
+					if v, ok := fromVersions[depName]; ok {
+						depVer = v
+					} else {
+						fmt.Fprintf(os.Stderr, "Warning: %s not found in manifest, using latest\n", depName)
+					}
+				}
 			}
+			deps[depName] = depVer
 		}
+		header[section] = deps
 
-		nodeModulesPath := filepath.Join(cacheDir, "node_modules")
-		if _, err := os.Stat(nodeModulesPath); os.IsNotExist(err) && len(deps) > 1 {
-			fmt.Fprintf(os.Stderr, "Installing packages...\n")
-			installCmd := exec.Command("bun", "install")
-			installCmd.Dir = cacheDir
-			installCmd.Stdout = os.Stderr
-			installCmd.Stderr = os.Stderr
-			if err := installCmd.Run(); err != nil {
-				fmt.Fprintf(os.Stderr, "Error installing packages: %v\n", err)
-				os.Exit(1)
+		newBlock, err := renderMetadataBlock(header, string(origBytes))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error serializing metadata: %v\n", err)
+			os.Exit(1)
+		}
+
+		// Reconstruct the file
+		var newContent string
+		if found {
+			newContent = before + newBlock + after
+		} else {
+			top, _ := cmd.Flags().GetBool("top")
+			beforeLine, _ := cmd.Flags().GetInt("before-line")
+			keepLines := 0
+			switch {
+			case beforeLine > 0:
+				keepLines = beforeLine - 1
+			case top:
+				keepLines = 0
 			}
+			// Even --top and --before-line 1 keep a shebang first, where
+			// the kernel looks for it
+			keepLines = max(keepLines, shebangLines(after))
+			newContent = insertBlock(after, newBlock, keepLines)
 		}
 
-		absScriptPath, err := filepath.Abs(scriptFile)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error getting absolute path: %v\n", err)
+		if err := os.WriteFile(scriptFile, []byte(newContent), 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing updated script: %v\n", err)
 			os.Exit(1)
 		}
+		fmt.Printf("Updated dependencies in %s\n", scriptFile)
+	},
+}
 
-		scriptBase := filepath.Base(scriptFile)
-		hardlinkScriptPath := filepath.Join(cacheDir, scriptBase)
-		os.Remove(hardlinkScriptPath)
-		if err := os.Link(absScriptPath, hardlinkScriptPath); err != nil {
-			fmt.Fprintf(os.Stderr, "Error creating hardlink to script file: %v\n", err)
+var initCmd = &cobra.Command{
+	Use:   "init <script.ts>",
+	Short: "Add an empty inline metadata block to a script, creating it if needed",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		scriptFile := args[0]
+		force, _ := cmd.Flags().GetBool("force")
+		if !isScriptFile(scriptFile) {
+			fmt.Fprintf(os.Stderr, "Error: %s can't hold a metadata block, since '//' comments are only valid in %s files\n", scriptFile, strings.Join(scriptExtensions, "/"))
 			os.Exit(1)
 		}
 
-		bunArgs := append([]string{"run", hardlinkScriptPath}, scriptArgs...)
-
-		// Set NODE_PATH to the cacheDir, plus any existing NODE_PATH
-		env := os.Environ()
-		nodePathSet := false
-		for i, v := range env {
-			if strings.HasPrefix(v, "NODE_PATH=") {
-				env[i] = fmt.Sprintf("NODE_PATH=%s%c%s", cacheDir, os.PathListSeparator, v[len("NODE_PATH="):])
-				nodePathSet = true
-				break
-			}
-		}
-		if !nodePathSet {
-			env = append(env, fmt.Sprintf("NODE_PATH=%s", cacheDir))
+		content := "#!/usr/bin/env -S bunv run --\n"
+		if origBytes, err := os.ReadFile(scriptFile); err == nil {
+			content = string(origBytes)
+		} else if !os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "Error reading script file: %v\n", err)
+			os.Exit(1)
 		}
 
-		bunPath, err := exec.LookPath("bun")
+		_, before, after, found := parseMetadataBlock(content)
+		if found && !force {
+			fmt.Fprintf(os.Stderr, "Error: %s already has a metadata block (use --force to replace it)\n", scriptFile)
+			os.Exit(1)
+		}
+		newBlock, err := renderMetadataBlock(map[string]any{"dependencies": map[string]any{}}, "")
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error finding bun executable: %v\n", err)
+			fmt.Fprintf(os.Stderr, "Error serializing metadata: %v\n", err)
 			os.Exit(1)
 		}
+		var newContent string
+		if found {
+			newContent = before + newBlock + after
+		} else {
+			newContent = insertBlock(after, newBlock, shebangLines(after))
+		}
 
-		bunArgs = append([]string{bunPath}, bunArgs...)
-		err = syscall.Exec(bunPath, bunArgs, env)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error executing bun: %v\n", err)
+		if err := os.WriteFile(scriptFile, []byte(newContent), 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing script: %v\n", err)
 			os.Exit(1)
 		}
+		fmt.Printf("Initialized metadata in %s\n", scriptFile)
 	},
 }
 
-var addCmd = &cobra.Command{
-	Use:   "add --script <script.ts> <dep[@version]>...",
-	Short: "Add dependencies to a TypeScript script's inline metadata",
+var removeCmd = &cobra.Command{
+	Use:   "remove --script <script.ts> <dep>...",
+	Short: "Remove dependencies from a TypeScript script's inline metadata",
 	Args:  cobra.MinimumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		scriptFile, _ := cmd.Flags().GetString("script")
-		if scriptFile == "" {
-			fmt.Fprintf(os.Stderr, "Error: --script flag is required\n")
-			os.Exit(1)
-		}
-		if _, err := os.Stat(scriptFile); os.IsNotExist(err) {
-			fmt.Fprintf(os.Stderr, "Error: File %s does not exist\n", scriptFile)
-			os.Exit(1)
-		}
-
-		// Read the whole file
 		origBytes, err := os.ReadFile(scriptFile)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error reading script file: %v\n", err)
 			os.Exit(1)
 		}
-		orig := string(origBytes)
-
-		// Regex to find the metadata block
-		blockRe := regexp.MustCompile(`(?ms)^// /// script\n(?P<block>(?:^//.*\n)*?)^// ///\n?`)
-		matches := blockRe.FindStringSubmatchIndex(orig)
-
-		var before, after, blockContent string
-		if matches != nil {
-			before = orig[:matches[0]]
-			after = orig[matches[1]:]
-			blockContent = orig[matches[2]:matches[3]]
-		} else {
-			before = ""
-			after = orig
-			blockContent = ""
-		}
-
-		// Extract JSON from blockContent
-		jsonLines := []string{}
-		for _, line := range strings.Split(blockContent, "\n") {
-			line = strings.TrimSpace(line)
-			if strings.HasPrefix(line, "//") {
-				jsonLines = append(jsonLines, strings.TrimSpace(strings.TrimPrefix(line, "//")))
-			}
-		}
-		jsonContent := strings.Join(jsonLines, "\n")
-		var header map[string]any
-		if jsonContent != "" {
-			_ = json.Unmarshal([]byte(jsonContent), &header)
+		header, before, after, found := parseMetadataBlock(string(origBytes))
+		if !found {
+			fmt.Fprintf(os.Stderr, "Error: %s has no '// /// script' metadata block\n", scriptFile)
+			os.Exit(1)
 		}
-		if header == nil {
-			header = map[string]any{}
+		section := "dependencies"
+		if dev, _ := cmd.Flags().GetBool("dev"); dev {
+			section = "devDependencies"
 		}
-		deps, _ := header["dependencies"].(map[string]any)
+		deps, _ := header[section].(map[string]any)
 		if deps == nil {
 			deps = map[string]any{}
 		}
 
-		// Parse new dependencies from args
 		for _, depArg := range args {
-			depName := depArg
-			depVer := "latest"
-			if at := strings.LastIndex(depArg, "@"); at > 0 {
-				depName = depArg[:at]
-				depVer = depArg[at+1:]
+			depName, _ := parsePackageSpec(depArg)
+			// Also remove any mixed-case spellings written by hand
+			removed := false
+			for name := range deps {
+				if strings.EqualFold(name, depName) {
+					delete(deps, name)
+					removed = true
+				}
+			}
+			if !removed {
+				fmt.Fprintf(os.Stderr, "Warning: %s is not in %s\n", depName, section)
 			}
-			deps[depName] = depVer
 		}
-		header["dependencies"] = deps
+		header[section] = deps
 
-		// Re-serialize the block
-		blockJSON, err := json.MarshalIndent(header, "", "  ")
+		newBlock, err := renderMetadataBlock(header, string(origBytes))
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error serializing metadata: %v\n", err)
 			os.Exit(1)
 		}
-		blockLines := []string{"// /// script"}
-		for _, line := range strings.Split(string(blockJSON), "\n") {
-			blockLines = append(blockLines, "// "+line)
+		if err := os.WriteFile(scriptFile, []byte(before+newBlock+after), 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing updated script: %v\n", err)
+			os.Exit(1)
 		}
-		blockLines = append(blockLines, "// ///")
-		newBlock := strings.Join(blockLines, "\n") + "\n"
+		fmt.Printf("Updated dependencies in %s\n", scriptFile)
+	},
+}
 
-		// Reconstruct the file
-		var newContent string
-		if matches != nil {
-			newContent = before + newBlock + after
-		} else {
-			// Insert at the top, with a blank line after block if file is not empty
-			if strings.TrimSpace(after) != "" {
-				newContent = newBlock + "\n" + after
-			} else {
-				newContent = newBlock
+// readManifestVersions returns the version ranges in a package.json.
+func readManifestVersions(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var manifest map[string]any
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, err
+	}
+	versions := headerStringMap(manifest, "devDependencies")
+	for k, v := range headerStringMap(manifest, "dependencies") {
+		versions[k] = v
+	}
+	return versions, nil
+}
+
+// metadataBlockRe matches a script's metadata block, capturing its comment lines.
+var metadataBlockRe = regexp.MustCompile(`(?ms)^// /// script\n(?P<block>(?:^//.*\n)*?)^// ///\n?`)
+
+// blockMetadataRe matches the '/* /// script' form of the metadata block.
+var blockMetadataRe = regexp.MustCompile(`(?ms)^[ \t]*/\* /// script[ \t]*\n(?P<block>.*?)^[ \t]*/// \*/[ \t]*(?:\n|\z)`)
+
+// findMetadataBlock returns the indexes of content's first metadata block, in either form.
+func findMetadataBlock(content string) (matches []int, blockComment bool) {
+	matches = metadataBlockRe.FindStringSubmatchIndex(content)
+	if block := blockMetadataRe.FindStringSubmatchIndex(content); block != nil && (matches == nil || block[0] < matches[0]) {
+		return block, true
+	}
+	return matches, false
+}
+
+// parseMetadataBlock returns the decoded header in content and the text around it.
+func parseMetadataBlock(content string) (header map[string]any, before, after string, found bool) {
+	body, before, after, found := splitMetadataBlock(content)
+	if body != "" {
+		header, _ = decodeMetadata(body)
+	}
+	if header == nil {
+		header = map[string]any{}
+	}
+	return header, before, after, found
+}
+
+// splitMetadataBlock returns the body of content's metadata block and the text around it.
+func splitMetadataBlock(content string) (body, before, after string, found bool) {
+	matches, blockComment := findMetadataBlock(content)
+	blockContent := ""
+	after = content
+	if matches != nil {
+		before = content[:matches[0]]
+		after = content[matches[1]:]
+		blockContent = content[matches[2]:matches[3]]
+		found = true
+	}
+
+	jsonLines := []string{}
+	for _, line := range strings.Split(blockContent, "\n") {
+		line = strings.TrimSpace(line)
+		if blockComment {
+			if line != "" {
+				jsonLines = append(jsonLines, line)
 			}
+		} else if strings.HasPrefix(line, "//") {
+			jsonLines = append(jsonLines, strings.TrimSpace(strings.TrimPrefix(line, "//")))
 		}
+	}
+	return strings.Join(jsonLines, "\n"), before, after, found
+}
 
-		if err := os.WriteFile(scriptFile, []byte(newContent), 0644); err != nil {
-			fmt.Fprintf(os.Stderr, "Error writing updated script: %v\n", err)
-			os.Exit(1)
+// metadataKeyOrder returns the top-level keys of a metadata block body in order.
+func metadataKeyOrder(body string, isTOML bool) []string {
+	if isTOML {
+		return tomlKeyOrder(body)
+	}
+	dec := json.NewDecoder(strings.NewReader(body))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil
+	}
+	var keys []string
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return keys
 		}
-		fmt.Printf("Updated dependencies in %s\n", scriptFile)
-	},
+		key, _ := tok.(string)
+		keys = append(keys, key)
+		var skip json.RawMessage
+		if err := dec.Decode(&skip); err != nil {
+			return keys
+		}
+	}
+	return keys
+}
+
+// renderMetadataBlock serializes header as a metadata block, ending in a newline.
+func renderMetadataBlock(header map[string]any, original string) (string, error) {
+	origBody, _, _, _ := splitMetadataBlock(original)
+	_, blockComment := findMetadataBlock(original)
+	// An empty body is valid TOML, but new blocks are written as JSON.
+	isTOML := false
+	if origBody != "" {
+		_, isTOML = decodeMetadata(origBody)
+	}
+	keyOrder := metadataKeyOrder(origBody, isTOML)
+
+	var keys []string
+	seen := map[string]bool{}
+	for _, key := range keyOrder {
+		if _, ok := header[key]; ok && !seen[key] {
+			keys = append(keys, key)
+			seen[key] = true
+		}
+	}
+	var rest []string
+	for key := range header {
+		if !seen[key] {
+			rest = append(rest, key)
+		}
+	}
+	sort.Strings(rest)
+	keys = append(keys, rest...)
+
+	var blockBody string
+	if isTOML {
+		var err error
+		if blockBody, err = renderTOML(header, keys); err != nil {
+			return "", err
+		}
+	} else if len(keys) == 0 {
+		blockBody = "{}"
+	} else {
+		fields := make([]string, 0, len(keys))
+		for _, key := range keys {
+			keyJSON, err := json.Marshal(key)
+			if err != nil {
+				return "", err
+			}
+			valueJSON, err := json.MarshalIndent(header[key], "  ", "  ")
+			if err != nil {
+				return "", err
+			}
+			fields = append(fields, "  "+string(keyJSON)+": "+string(valueJSON))
+		}
+		blockBody = "{\n" + strings.Join(fields, ",\n") + "\n}"
+	}
+
+	if blockComment {
+		opener, inner, closer := blockCommentIndent(original)
+		blockLines := []string{opener + "/* /// script"}
+		for _, line := range strings.Split(blockBody, "\n") {
+			blockLines = append(blockLines, strings.TrimRight(inner+line, " \t"))
+		}
+		blockLines = append(blockLines, closer+"/// */")
+		return strings.Join(blockLines, "\n") + "\n", nil
+	}
+	blockLines := []string{"// /// script"}
+	for _, line := range strings.Split(blockBody, "\n") {
+		blockLines = append(blockLines, strings.TrimRight("// "+line, " "))
+	}
+	blockLines = append(blockLines, "// ///")
+	return strings.Join(blockLines, "\n") + "\n", nil
+}
+
+// blockCommentIndent returns the indentation used in original's block-comment metadata block.
+func blockCommentIndent(original string) (opener, inner, closer string) {
+	matches, _ := findMetadataBlock(original)
+	if matches == nil {
+		return "", "", ""
+	}
+	block := original[matches[0]:matches[1]]
+	lines := strings.Split(strings.TrimSuffix(block, "\n"), "\n")
+	indent := func(line string) string {
+		return line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+	}
+	opener, closer = indent(lines[0]), indent(lines[len(lines)-1])
+	for _, line := range lines[1 : len(lines)-1] {
+		if strings.TrimSpace(line) != "" {
+			return opener, indent(line), closer
+		}
+	}
+	return opener, opener, closer
+}
+
+// shebangLines returns 1 if content starts with a '#!' line, otherwise 0.
+func shebangLines(content string) int {
+	if strings.HasPrefix(content, "#!") {
+		return 1
+	}
+	return 0
+}
+
+// insertBlock inserts block into content after its first keepLines lines.
+func insertBlock(content, block string, keepLines int) string {
+	lines := strings.SplitAfter(content, "\n")
+	if keepLines > len(lines) {
+		keepLines = len(lines)
+	}
+	head := strings.Join(lines[:keepLines], "")
+	rest := strings.Join(lines[keepLines:], "")
+	if head != "" && !strings.HasSuffix(head, "\n") {
+		head += "\n"
+	}
+	if strings.TrimSpace(rest) != "" {
+		return head + block + "\n" + rest
+	}
+	return head + block
 }
 
 func init() {
 	runCmd.Flags().StringSliceVar(&withPackages, "with", []string{}, "Packages to install temporarily")
+	runCmd.Flags().BoolVar(&withOverride, "with-override", false, "Let --with versions take precedence over the script's header")
+	runCmd.Flags().StringArrayVar(&installArgs, "install-args", []string{}, "Extra arguments appended to 'bun install', split like a shell command line (not part of the cache hash; may affect reproducibility)")
+	runCmd.Flags().BoolVar(&failOnInstallWarning, "fail-fast-on-install-warning", false, "Fail the run if 'bun install' output contains a warning")
+	runCmd.Flags().StringArrayVar(&installWarningPatterns, "install-warning-pattern", defaultInstallWarningPatterns, "Regular expression identifying an install warning (repeatable)")
+	runCmd.Flags().StringArrayVar(&assetPatterns, "copy-assets", []string{}, "Glob of files next to the script to copy into the run directory (repeatable)")
+	runCmd.Flags().BoolVar(&keepTemp, "keep-temp", false, "Keep copied assets in the run directory after the script exits")
+	runCmd.Flags().StringVar(&dependencyReportPath, "dependency-report", "", "Write a JSON report of the resolved and installed dependencies to this file after a successful run")
+	runCmd.Flags().IntVar(&retryOnCrash, "retry-on-crash", 0, "Retry up to N times when bun is killed by a signal, waiting 1s, 2s, 4s, ... in between (non-zero exits are not retried)")
+	runCmd.Flags().BoolVar(&resolveSymlinks, "resolve-symlinks", false, "Resolve symlinks in the script path before linking it into the run directory")
+	runCmd.Flags().BoolVar(&prewarmTypes, "prewarm-types", false, "Install only @types/* packages into a separate cache entry, print its path and exit without running")
+	runCmd.Flags().BoolVar(&dependencyHashOnly, "dependency-hash-only", false, "Print the script's cache key (its dependencies, bun version, registry and aliases; needs bun) and exit")
+	runCmd.Flags().BoolVar(&useSandbox, "sandbox", false, "Run the script (not installs or hooks) with a restricted environment, filesystem and network where supported")
+	runCmd.Flags().StringVar(&dedupeWith, "dedupe-with", "", "Install the union of this script's and another script's dependencies into one shared cache entry")
+	runCmd.Flags().StringVar(&onMissHook, "on-miss", "", "Shell command to run on a cache miss (receives the cache hash and script path as $1 and $2)")
+	runCmd.Flags().StringVar(&onHitHook, "on-hit", "", "Shell command to run on a cache hit (receives the cache hash and script path as $1 and $2)")
+	runCmd.Flags().DurationVar(&networkTimeout, "network-timeout", networkTimeout, "Timeout for outbound network requests made by bunv (not bun install)")
+	runCmd.Flags().BoolVar(&deterministic, "deterministic", false, "Refuse to run unless dependencies are exactly pinned or locked, and install with a frozen lockfile")
+	runCmd.Flags().BoolVar(&printInstallPlan, "print-install-plan", false, "Print the packages, cache directory and install command before installing, and ask for confirmation")
+	runCmd.Flags().BoolVarP(&assumeYes, "yes", "y", false, "Don't ask for confirmation before installing")
+	runCmd.Flags().StringVar(&scriptName, "script-name", "", "Run the named command from the script's \"scripts\" header instead of the script itself")
+	runCmd.Flags().StringVar(&minBun, "min-bun", "", "Minimum bun version required to run the script (overrides the header's bun.minVersion)")
+	runCmd.Flags().StringVar(&transformHeaderCmd, "transform-header", "", "Shell command that receives the metadata header as JSON on stdin and prints the header to use")
+	runCmd.Flags().StringVar(&keepPackageJSON, "keep-package-json", "", "Also write the generated package.json to this path")
+	runCmd.Flags().StringVar(&runMode, "mode", "", "Run in development or production mode (sets NODE_ENV and import conditions; production installs with a frozen lockfile)")
+	runCmd.Flags().BoolVar(&failOnMissingTypes, "fail-on-missing-types", false, "Typecheck imports with tsc before running and fail if a dependency has no type declarations")
+	runCmd.Flags().StringVar(&execWrapper, "exec-wrapper", "", "Command to run bun under, e.g. 'strace -f' or 'time'")
+	runCmd.Flags().StringVar(&limitMemory, "limit-memory", "", "Limit the script's data memory, in bytes or with a K/M/G suffix")
+	runCmd.Flags().IntVar(&limitCPU, "limit-cpu", 0, "Limit the script's CPU time in seconds")
+	runCmd.Flags().StringVar(&scriptStdin, "script-stdin", "", "File to feed to the script's stdin ('-' for bunv's own stdin)")
+	runCmd.Flags().StringVar(&captureMetricsPath, "capture-metrics", "", "Write timing and resource usage of the run as JSON to this file")
+	runCmd.Flags().StringArrayVar(&aliasFlags, "alias", []string{}, "Alias a bare module specifier to a local path, as name=path relative to the script (repeatable)")
+	runCmd.Flags().BoolVar(&failOnEmptyDeps, "fail-on-empty-deps", false, "Fail if the script declares no dependencies besides @types/node")
+	runCmd.Flags().StringVar(&bunInstallCache, "bun-install-cache", "", "Directory for bun's global package cache during install (sets BUN_INSTALL_CACHE_DIR)")
+	runCmd.Flags().BoolVar(&printTreeHash, "print-tree-hash", false, "Install, then print a hash of the dependencies and lockfile and exit")
+	runCmd.Flags().IntVar(&maxInstallOutputLines, "max-install-output-lines", 0, "Show at most N lines of install output (the full output is shown if the install fails)")
+	runCmd.Flags().StringVar(&verifyScriptHash, "verify-script-hash", "", "Refuse to run unless the script's SHA-256 matches this hex digest")
+	runCmd.Flags().StringVar(&dependencyBlocklist, "dependency-blocklist", "", "File of package name globs that must never be installed (default $BUNV_DEPENDENCY_BLOCKLIST)")
+	runCmd.Flags().StringVar(&writeSBOMPath, "write-sbom", "", "Write a CycloneDX JSON SBOM of the installed packages to this file")
+	runCmd.Flags().BoolVar(&rerunLast, "rerun-last", false, "Repeat the previous 'bunv run' invocation")
+	runCmd.Flags().BoolVar(&editLast, "edit", false, "With --rerun-last, print the recorded command instead of running it")
+	runCmd.Flags().StringVar(&mergeEnvFrom, "merge-env-from", "", "Inherit env and dependencies from another script's header (the script's own header wins)")
+	runCmd.Flags().BoolVar(&strictDeps, "strict-deps", false, "On a cache hit, reinstall if an installed package no longer satisfies its declared version range")
+	runCmd.Flags().StringVar(&emitRunScript, "emit-run-script", "", "Write a standalone launcher for the script to this path and exit (.cmd for a Windows batch file)")
+	runCmd.Flags().BoolVar(&parallelScripts, "parallel-scripts", false, "Start the header's \"sidecars\" before the script and stop them after it exits")
+	runCmd.Flags().StringVar(&cacheDirMode, "cache-dir-mode", "", "Octal permissions for cache directories, e.g. 0775 for a group-shared cache (files get the same bits without execute; default 0755)")
+	runCmd.Flags().StringSliceVar(&verifyBins, "post-install-verify-bins", []string{}, "Fail unless these binaries exist in node_modules/.bin after install (comma-separated)")
+	runCmd.Flags().StringVar(&resolutionReportPath, "dependency-resolution-report", "", "Write a JSON trace of every version proposed for each package and which one won")
+	runCmd.Flags().BoolVar(&noCache, "no-cache", false, "Install into a fresh temporary directory that is removed after the run, bypassing the cache")
+	runCmd.Flags().BoolVar(&refresh, "refresh", false, "Reinstall dependencies into the existing cache entry, e.g. to pick up a new 'latest'")
+	runCmd.Flags().StringVar(&bunPathOverride, "bun-path", "", "bun executable to install and run with instead of the one on PATH (default $BUNV_BUN)")
+	runCmd.Flags().BoolVar(&noTypes, "no-types", false, "Don't add @types/node to the dependencies")
+	runCmd.Flags().StringVar(&typesNodeVersion, "types-node-version", "", "Version of @types/node to add (overrides the header's \"typesNode\"; default latest)")
+	runCmd.Flags().BoolVar(&noRemote, "no-remote", false, "Refuse to fetch and run scripts given as http(s) URLs")
+	runCmd.Flags().BoolVar(&watch, "watch", false, "Re-run the script whenever it changes, reinstalling if its dependencies changed")
+	runCmd.Flags().BoolVar(&pinVersions, "pin", false, "After installing, rewrite the script's dependencies to the exact versions installed")
+	runCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Print the dependencies, cache directory, whether an install is needed and the bun command, without installing or running anything")
+	runCmd.Flags().BoolVar(&frozen, "frozen", false, "Install from the lockfile saved next to the script (script.ts.lock), saving one after the first install")
+	runCmd.Flags().StringVar(&registry, "registry", "", "npm registry URL to install from (overrides the header's \"registry\")")
+	runCmd.Flags().StringArrayVar(&envFiles, "env-file", []string{}, "Load environment variables for the script from a dotenv file; later files win (repeatable)")
+	runCmd.Flags().BoolVar(&envOverride, "env-override", false, "Let variables already set in bunv's environment take precedence over --env-file")
+	runCmd.Flags().BoolVar(&saveWith, "save", false, "After a successful run, add the --with packages to the script's metadata block")
+	runCmd.Flags().BoolVar(&saveExact, "save-exact", false, "Like --save, but save the exact versions installed")
+	runCmd.Flags().BoolVar(&strictMetadata, "strict", false, "Fail instead of warning when the script's metadata block is malformed")
+	runCmd.Flags().BoolVar(&offline, "offline", false, "Never install or fetch anything; fail unless the dependencies are already in the cache")
+	runCmd.MarkFlagsMutuallyExclusive("offline", "refresh")
+	runCmd.MarkFlagsMutuallyExclusive("offline", "no-cache")
+	// Both leave a path to the cache entry behind, which --no-cache deletes
+	runCmd.MarkFlagsMutuallyExclusive("no-cache", "emit-run-script")
+	runCmd.MarkFlagsMutuallyExclusive("no-cache", "prewarm-types")
+	runCmd.MarkFlagsMutuallyExclusive("sandbox", "parallel-scripts")
+	runCmd.Flags().DurationVar(&installTimeout, "install-timeout", 2*time.Minute, "Kill bun install if it takes longer than this (0 for no limit)")
+	runCmd.Flags().IntVar(&installRetries, "install-retries", 0, "Retry a failed bun install up to N times, waiting 1s, 2s, 4s, ... in between")
+	runCmd.Flags().StringArrayVar(&bunRuntimeArgs, "bun-arg", []string{}, "Flag for 'bun run' itself, such as --smol, added after the header's \"bunArgs\" (repeatable)")
 	rootCmd.AddCommand(runCmd)
 	addCmd.Flags().String("script", "", "Script file to update")
 	addCmd.MarkFlagRequired("script")
+	addCmd.Flags().String("from", "", "package.json to take versions from for packages given without one")
+	addCmd.Flags().Bool("dev", false, "Add to devDependencies instead of dependencies")
+	addCmd.Flags().Bool("after-shebang", true, "Insert a new metadata block after the shebang line, if any (default)")
+	addCmd.Flags().Bool("top", false, "Insert a new metadata block at the top of the file (still after a shebang line)")
+	addCmd.Flags().Int("before-line", 0, "Insert a new metadata block before this 1-based line number")
+	addCmd.MarkFlagsMutuallyExclusive("after-shebang", "top", "before-line")
 	rootCmd.AddCommand(addCmd)
+	initCmd.Flags().Bool("force", false, "Replace an existing metadata block with an empty one")
+	rootCmd.AddCommand(initCmd)
+	removeCmd.Flags().String("script", "", "Script file to update")
+	removeCmd.MarkFlagRequired("script")
+	removeCmd.Flags().Bool("dev", false, "Remove from devDependencies instead of dependencies")
+	rootCmd.AddCommand(removeCmd)
 }
 
-// extractDependenciesFromHeader scans for a block starting with '// /// script', ending with '// ///', and parses the JSON content in between.
-func extractDependenciesFromHeader(scriptPath string) (map[string]string, error) {
+// A metadataError is a malformed metadata block, with the line of the problem.
+type metadataError struct {
+	Line int
+	Err  error
+}
+
+func (e *metadataError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+// extractHeader parses the metadata block of the script at scriptPath.
+func extractHeader(scriptPath string) (map[string]any, error) {
 	f, err := os.Open(scriptPath)
 	if err != nil {
 		return nil, err
 	}
 	defer f.Close()
 
-	scanner := bufio.NewScanner(f)
-	inBlock := false
-	var jsonLines []string
-	for scanner.Scan() {
-		line := scanner.Text()
+	// A bufio.Reader rather than a Scanner, which gives up on lines over
+	// 64KB, common in bundled files.
+	reader := bufio.NewReader(f)
+	inBlock, blockComment := false, false
+	var bodyLines []string
+	// bodyLineNums[i] is the line of the script that bodyLines[i] came from
+	var bodyLineNums []int
+	lineNum := 0
+	for {
+		line, readErr := reader.ReadString('\n')
+		if line == "" && readErr != nil {
+			if readErr != io.EOF {
+				return nil, readErr
+			}
+			break
+		}
+		lineNum++
 		trimmed := strings.TrimSpace(line)
 		if !inBlock {
 			if trimmed == "// /// script" {
 				inBlock = true
+			} else if trimmed == "/* /// script" {
+				inBlock, blockComment = true, true
+			}
+			continue
+		}
+		if blockComment {
+			if trimmed == "/// */" {
+				break
+			}
+			if trimmed != "" {
+				bodyLines = append(bodyLines, trimmed)
+				bodyLineNums = append(bodyLineNums, lineNum)
 			}
 			continue
 		}
@@ -315,29 +1985,154 @@ func extractDependenciesFromHeader(scriptPath string) (map[string]string, error)
 			break
 		}
 		if strings.HasPrefix(trimmed, "//") {
-			jsonLines = append(jsonLines, strings.TrimSpace(strings.TrimPrefix(trimmed, "//")))
+			bodyLines = append(bodyLines, strings.TrimSpace(strings.TrimPrefix(trimmed, "//")))
+			bodyLineNums = append(bodyLineNums, lineNum)
 		}
 	}
-	if len(jsonLines) == 0 {
-		return nil, nil // No block found
-	}
-	jsonContent := strings.Join(jsonLines, "\n")
 	var header map[string]any
-	if err := json.Unmarshal([]byte(jsonContent), &header); err != nil {
-		return nil, nil // Invalid JSON
+	var parseErr error
+	if len(bodyLines) > 0 {
+		body := strings.Join(bodyLines, "\n")
+		if header, _ = decodeMetadata(body); header == nil {
+			line, err := metadataSyntaxError(body)
+			line = bodyLineNums[min(max(line, 1), len(bodyLineNums))-1]
+			parseErr = &metadataError{Line: line, Err: err}
+		}
+	}
+	if transformHeaderCmd != "" {
+		return transformHeader(scriptPath, header), parseErr
+	}
+	return header, parseErr
+}
+
+// transformedHeaders memoizes --transform-header output per script.
+var transformedHeaders = map[string]map[string]any{}
+
+// transformHeader pipes header as JSON through the --transform-header command.
+func transformHeader(scriptPath string, header map[string]any) map[string]any {
+	if cached, ok := transformedHeaders[scriptPath]; ok {
+		return cached
+	}
+	if header == nil {
+		header = map[string]any{}
+	}
+	input, err := json.Marshal(header)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error serializing metadata: %v\n", err)
+		os.Exit(1)
+	}
+
+	transformCmd := exec.Command("sh", "-c", transformHeaderCmd)
+	transformCmd.Stdin = bytes.NewReader(input)
+	transformCmd.Stderr = os.Stderr
+	transformCmd.Env = append(os.Environ(), "BUNV_SCRIPT="+scriptPath)
+	output, err := transformCmd.Output()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error running header transform %q: %v\n", transformHeaderCmd, err)
+		os.Exit(1)
+	}
+	var transformed map[string]any
+	if err := json.Unmarshal(output, &transformed); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: Header transform %q did not print a JSON object: %v\n", transformHeaderCmd, err)
+		os.Exit(1)
 	}
-	deps := map[string]string{}
-	if depObj, ok := header["dependencies"].(map[string]any); ok {
-		for k, v := range depObj {
+	transformedHeaders[scriptPath] = transformed
+	return transformed
+}
+
+// headerStringMap returns the string values of the header object under key.
+func headerStringMap(header map[string]any, key string) map[string]string {
+	values := map[string]string{}
+	if obj, ok := header[key].(map[string]any); ok {
+		for k, v := range obj {
 			if s, ok := v.(string); ok {
-				deps[k] = s
+				values[k] = s
 			}
 		}
 	}
-	return deps, nil
+	return values
+}
+
+// extractDependenciesFromHeader returns the "dependencies" of the script's metadata block.
+func extractDependenciesFromHeader(scriptPath string) (map[string]string, error) {
+	header, err := extractHeader(scriptPath)
+	if header == nil {
+		return nil, err
+	}
+	return headerStringMap(header, "dependencies"), err
+}
+
+// extractDevDependenciesFromHeader returns the "devDependencies" of the script's metadata block.
+func extractDevDependenciesFromHeader(scriptPath string) (map[string]string, error) {
+	header, err := extractHeader(scriptPath)
+	if header == nil {
+		return nil, err
+	}
+	return headerStringMap(header, "devDependencies"), err
+}
+
+// devDependencyNames returns the packages only listed in the header's "devDependencies".
+func devDependencyNames(scriptPath string) map[string]bool {
+	devDeps, _ := extractDevDependenciesFromHeader(scriptPath)
+	deps, _ := extractDependenciesFromHeader(scriptPath)
+	names := map[string]bool{}
+	for name := range devDeps {
+		if _, ok := deps[name]; !ok {
+			names[name] = true
+		}
+	}
+	return names
+}
+
+// extractEnvFromHeader returns the "env" variables of the script's metadata block.
+func extractEnvFromHeader(scriptPath string) map[string]string {
+	header, _ := extractHeader(scriptPath)
+	return headerStringMap(header, "env")
+}
+
+// extractScriptsFromHeader returns the "scripts" of the script's metadata block.
+func extractScriptsFromHeader(scriptPath string) (map[string]string, error) {
+	header, err := extractHeader(scriptPath)
+	if err != nil || header == nil {
+		return nil, err
+	}
+	return headerStringMap(header, "scripts"), nil
+}
+
+// interpreterArgs normalizes the arguments bunv receives as a shebang interpreter.
+func interpreterArgs(args []string) []string {
+	if len(args) < 2 || !strings.HasPrefix(args[0], "run") || !strings.ContainsAny(args[0], " \t") {
+		return args
+	}
+	words, err := splitArgs(args[0])
+	if err != nil || words[0] != "run" || !hasShebang(args[1]) {
+		return args
+	}
+	out := append([]string{}, words...)
+	if !slices.Contains(words, "--") {
+		out = append(out, "--")
+	}
+	return append(out, args[1:]...)
+}
+
+// hasShebang reports whether path is a regular file starting with "#!".
+func hasShebang(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	if info, err := f.Stat(); err != nil || !info.Mode().IsRegular() {
+		return false
+	}
+	magic := make([]byte, 2)
+	_, err = io.ReadFull(f, magic)
+	return err == nil && string(magic) == "#!"
 }
 
 func main() {
+	registerCompletions()
+	rootCmd.SetArgs(interpreterArgs(os.Args[1:]))
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
@@ -0,0 +1,676 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// bunvTestMainEnv makes the test binary run as bunv, for runBunv.
+const bunvTestMainEnv = "BUNV_TEST_MAIN"
+
+func TestMain(m *testing.M) {
+	// limitedCommand re-runs the executable, which in tests is this binary
+	if os.Getenv(bunvTestMainEnv) != "" || len(os.Args) > 1 && os.Args[1] == limitExecCmd.Name() {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func TestParseMissingTypes(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  string
+		failed  bool
+		want    []string
+		wantErr bool
+	}{
+		{name: "clean", output: "", want: nil},
+		{
+			name: "missing types",
+			output: "s.ts(1,17): error TS7016: Could not find a declaration file for module 'left-pad'. '/c/node_modules/left-pad/index.js' implicitly has an 'any' type.\n" +
+				"s.ts(2,17): error TS7016: Could not find a declaration file for module 'chalk-ish'.\n" +
+				"s.ts(3,17): error TS7016: Could not find a declaration file for module 'left-pad'.\n",
+			failed: true,
+			want:   []string{"chalk-ish", "left-pad"},
+		},
+		{name: "other diagnostics", output: "s.ts(4,1): error TS2304: Cannot find name 'foo'.\n", failed: true, want: nil},
+		{name: "tsc did not run", output: "error: could not determine executable to run for package tsc\n", failed: true, wantErr: true},
+		{name: "failed silently", output: "", failed: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseMissingTypes(tt.output, tt.failed)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInterpreterArgs(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "s.ts")
+	if err := os.WriteFile(script, []byte("#!/usr/local/bin/bunv run --no-types\nconsole.log(1)\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	plain := filepath.Join(dir, "plain.ts")
+	if err := os.WriteFile(plain, []byte("console.log(1)\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{
+			name: "joined shebang argument",
+			args: []string{"run --no-types", script, "--dry-run"},
+			want: []string{"run", "--no-types", "--", script, "--dry-run"},
+		},
+		{
+			name: "joined shebang argument ending in --",
+			args: []string{"run --no-types --", script, "--dry-run"},
+			want: []string{"run", "--no-types", "--", script, "--dry-run"},
+		},
+		{
+			name: "quoted shebang argument",
+			args: []string{`run --with "left-pad@1.3.0"`, script},
+			want: []string{"run", "--with", "left-pad@1.3.0", "--", script},
+		},
+		{
+			name: "typed on the command line",
+			args: []string{"run", script, "--dry-run"},
+			want: []string{"run", script, "--dry-run"},
+		},
+		{
+			name: "typed with flags",
+			args: []string{"run", "--verbose", script, "-x"},
+			want: []string{"run", "--verbose", script, "-x"},
+		},
+		{
+			name: "joined argument before a file without a shebang",
+			args: []string{"run --verbose", plain},
+			want: []string{"run --verbose", plain},
+		},
+		{
+			name: "other command",
+			args: []string{"list", script},
+			want: []string{"list", script},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := interpreterArgs(tt.args); !slices.Equal(got, tt.want) {
+				t.Errorf("interpreterArgs(%q) = %q, want %q", tt.args, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePackageSpec(t *testing.T) {
+	tests := []struct {
+		spec, name, version string
+	}{
+		{"react", "react", ""},
+		{"react@18", "react", "18"},
+		{"@types/node", "@types/node", ""},
+		{"@types/node@20.11.0", "@types/node", "20.11.0"},
+		{"alias@npm:real@1", "alias", "npm:real@1"},
+		{"React@18", "react", "18"},
+		{"github:owner/Repo#v1", "repo", "github:owner/Repo#v1"},
+		{"git+https://github.com/owner/repo.git#v1", "repo", "git+https://github.com/owner/repo.git#v1"},
+	}
+	for _, tt := range tests {
+		name, version := parsePackageSpec(tt.spec)
+		if name != tt.name || version != tt.version {
+			t.Errorf("parsePackageSpec(%q) = %q, %q; want %q, %q", tt.spec, name, version, tt.name, tt.version)
+		}
+	}
+}
+
+func TestGitSpecName(t *testing.T) {
+	tests := []struct {
+		spec, want string
+	}{
+		{"github:owner/repo", "repo"},
+		{"github:owner/repo#v1.2.0", "repo"},
+		{"gitlab:group/Project", "project"},
+		{"bitbucket:team/repo/", "repo"},
+		{"git+https://github.com/owner/repo.git#main", "repo"},
+		{"git+ssh://git@github.com:owner/repo.git", "repo"},
+		{"git://example.com/repo.git", "repo"},
+		{"repo", ""},
+		{"react@18", ""},
+		{"file:../github:x", ""},
+	}
+	for _, tt := range tests {
+		if got := gitSpecName(tt.spec); got != tt.want {
+			t.Errorf("gitSpecName(%q) = %q, want %q", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestResolveLocalSpec(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	base := filepath.Join(t.TempDir(), "scripts")
+	tests := []struct {
+		version, want string
+	}{
+		{"file:../pkg", "file:" + filepath.Join(filepath.Dir(base), "pkg")},
+		{"file:pkg", "file:" + filepath.Join(base, "pkg")},
+		{"./pkg", "file:" + filepath.Join(base, "pkg")},
+		{"../pkg", "file:" + filepath.Join(filepath.Dir(base), "pkg")},
+		{"/opt/pkg", "file:/opt/pkg"},
+		{"file:/opt/pkg/", "file:/opt/pkg"},
+		{"~/pkg", "file:" + filepath.Join(home, "pkg")},
+		{"^1.2.0", "^1.2.0"},
+		{"npm:real@1", "npm:real@1"},
+		{"github:owner/repo", "github:owner/repo"},
+	}
+	for _, tt := range tests {
+		if got := resolveLocalSpec(tt.version, base); got != tt.want {
+			t.Errorf("resolveLocalSpec(%q) = %q, want %q", tt.version, got, tt.want)
+		}
+	}
+}
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    []string
+		wantErr bool
+	}{
+		{s: "", want: nil},
+		{s: "run --no-types", want: []string{"run", "--no-types"}},
+		{s: "  a \t b\n", want: []string{"a", "b"}},
+		{s: `--with 'a b' "c d"`, want: []string{"--with", "a b", "c d"}},
+		{s: `'it''s' "say \"hi\""`, want: []string{"its", `say "hi"`}},
+		{s: `a\ b ''`, want: []string{"a b", ""}},
+		{s: `'$HOME' ~`, want: []string{"$HOME", "~"}},
+		{s: `"unterminated`, wantErr: true},
+		{s: `trailing\`, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := splitArgs(tt.s)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("splitArgs(%q) err = %v, wantErr %v", tt.s, err, tt.wantErr)
+			continue
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("splitArgs(%q) = %q, want %q", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestDependenciesHash(t *testing.T) {
+	deps := Dependencies{"zod": "3", "left-pad": "1.3.0"}
+	// The key names cache entries on disk, so it must not change between
+	// releases
+	if got := deps.HashString(); got != "a57c857d3f0a2c1a" {
+		t.Errorf("HashString() = %q, want a57c857d3f0a2c1a", got)
+	}
+	if got := (Dependencies{"left-pad": "1.3.0", "zod": "3"}).HashString(); got != deps.HashString() {
+		t.Errorf("HashString depends on insertion order: %q", got)
+	}
+	if (Dependencies{"zod": "3.1"}).HashString() == (Dependencies{"zod": "3"}).HashString() {
+		t.Error("different versions hash the same")
+	}
+
+	if got := deps.CacheKey("", "", nil); got != deps.HashString() {
+		t.Errorf("CacheKey with no bun version, registry or aliases = %q, want the plain hash", got)
+	}
+	keys := map[string]string{}
+	for _, args := range []struct {
+		bunVersion, registry string
+		aliases              map[string]string
+	}{
+		{"1.1.0", "", nil},
+		{"1.2.0", "", nil},
+		{"", "https://npm.example.com/", nil},
+		{"1.1.0", "https://npm.example.com/", nil},
+		{"", "", map[string]string{"@lib": "/src/lib"}},
+		{"", "", map[string]string{"@lib": "/other/lib"}},
+	} {
+		key := deps.CacheKey(args.bunVersion, args.registry, args.aliases)
+		if other, ok := keys[key]; ok || key == deps.HashString() {
+			t.Errorf("CacheKey%v = %q, same as %q", args, key, other)
+		}
+		keys[key] = fmt.Sprint(args)
+	}
+}
+
+func TestCacheDirFromEnv(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+
+	t.Setenv("BUNV_CACHE_DIR", "")
+	if got, want := getCacheDir("abc"), filepath.Join(home, ".bunv", "cache", "abc"); got != want {
+		t.Errorf("without BUNV_CACHE_DIR, getCacheDir = %s, want %s", got, want)
+	}
+
+	root := filepath.Join(t.TempDir(), "ci-cache")
+	t.Setenv("BUNV_CACHE_DIR", root)
+	if got, want := getCacheDir("abc"), filepath.Join(root, "abc"); got != want {
+		t.Errorf("with BUNV_CACHE_DIR, getCacheDir = %s, want %s", got, want)
+	}
+}
+
+func TestWriteAliasConfig(t *testing.T) {
+	cacheDir := t.TempDir()
+	configPath := filepath.Join(cacheDir, "tsconfig.json")
+	if err := writeAliasConfig(cacheDir, map[string]string{"@lib": "/src/lib"}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"@lib/*": [`) || !strings.Contains(string(data), `"/src/lib/*"`) {
+		t.Errorf("tsconfig.json = %s", data)
+	}
+	entries, _ := os.ReadDir(cacheDir)
+	if len(entries) != 1 {
+		t.Errorf("cache entry holds %d files, want only tsconfig.json", len(entries))
+	}
+
+	if err := writeAliasConfig(cacheDir, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(configPath); !os.IsNotExist(err) {
+		t.Errorf("tsconfig.json left behind without aliases: %v", err)
+	}
+}
+
+// writeScript writes content to a script in a temporary directory.
+func writeScript(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "script.ts")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestExtractHeader(t *testing.T) {
+	longHeader := strings.Repeat("// Licensed under the Apache License, Version 2.0\n", 200) +
+		"const minified = \"" + strings.Repeat("x", 100_000) + "\";\n"
+	tests := []struct {
+		name    string
+		content string
+		want    map[string]any
+	}{
+		{
+			name:    "JSON",
+			content: "// /// script\n// {\n//   \"dependencies\": {\"zod\": \"3\"}\n// }\n// ///\nconsole.log(1)\n",
+			want:    map[string]any{"dependencies": map[string]any{"zod": "3"}},
+		},
+		{
+			name:    "TOML",
+			content: "#!/usr/bin/env -S bunv run --\n// /// script\n// bunVersion = \">=1.1\"\n// [dependencies]\n// zod = \"3\"\n// ///\n",
+			want:    map[string]any{"bunVersion": ">=1.1", "dependencies": map[string]any{"zod": "3"}},
+		},
+		{
+			name:    "block comment",
+			content: "/* /// script\n  {\n    \"dependencies\": {\"zod\": \"3\"}\n  }\n/// */\n",
+			want:    map[string]any{"dependencies": map[string]any{"zod": "3"}},
+		},
+		{
+			name:    "long header",
+			content: longHeader + "// /// script\n// {\"dependencies\": {\"zod\": \"3\"}}\n// ///\n",
+			want:    map[string]any{"dependencies": map[string]any{"zod": "3"}},
+		},
+		{
+			name:    "no block",
+			content: "console.log(1)\n",
+			want:    nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := extractHeader(writeScript(t, tt.content))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractHeaderReportsLine(t *testing.T) {
+	script := writeScript(t, "#!/usr/bin/env -S bunv run --\n// /// script\n// {\n//   \"dependencies\": {\"zod\": \"3\",}\n// }\n// ///\n")
+	header, err := extractHeader(script)
+	var metaErr *metadataError
+	if header != nil || !errors.As(err, &metaErr) {
+		t.Fatalf("got %v, %v; want a *metadataError", header, err)
+	}
+	if metaErr.Line != 4 {
+		t.Errorf("error on line %d, want 4", metaErr.Line)
+	}
+}
+
+func TestLicenseHeaderBeforeMetadata(t *testing.T) {
+	license := "/*\n" + strings.Repeat(" * Licensed under the Apache License, Version 2.0\n", 98) + " */\n"
+	if n := strings.Count(license, "\n"); n != 100 {
+		t.Fatalf("license header is %d lines", n)
+	}
+	// Scanning stops at the first block, so the malformed one after it is never read
+	script := writeScript(t, license+"// /// script\n// {\"dependencies\": {\"zod\": \"3\"}}\n// ///\n"+
+		"console.log(1)\n// /// script\n// {\"dependencies\": {,}}\n// ///\n")
+	deps, err := extractDependenciesFromHeader(script)
+	if err != nil || !maps.Equal(deps, map[string]string{"zod": "3"}) {
+		t.Errorf("extractDependenciesFromHeader = %v, %v; want zod", deps, err)
+	}
+}
+
+func TestRenderMetadataBlockRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		original string
+	}{
+		{name: "new block", original: ""},
+		{name: "JSON", original: "// /// script\n// {\n//   \"typesNode\": false,\n//   \"dependencies\": {\n//     \"zod\": \"3\"\n//   }\n// }\n// ///\n"},
+		{name: "TOML", original: "// /// script\n// typesNode = false\n//\n// [dependencies]\n// zod = \"3\"\n// ///\n"},
+		{name: "block comment", original: "/* /// script\n{\n  \"typesNode\": false,\n  \"dependencies\": {\n    \"zod\": \"3\"\n  }\n}\n/// */\n"},
+		{name: "indented block comment", original: "  /* /// script\n    {\n      \"typesNode\": false,\n      \"dependencies\": {\n        \"zod\": \"3\"\n      }\n    }\n  /// */\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := map[string]any{"typesNode": false, "dependencies": map[string]any{"zod": "3"}}
+			block, err := renderMetadataBlock(header, tt.original)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.original != "" && block != tt.original {
+				t.Errorf("block changed:\n%s\nwant:\n%s", block, tt.original)
+			}
+			parsed, _, _, found := parseMetadataBlock(block + "console.log(1)\n")
+			if !found || fmt.Sprint(parsed) != fmt.Sprint(header) {
+				t.Errorf("rendered block parses as %v (found %v), want %v:\n%s", parsed, found, header, block)
+			}
+		})
+	}
+}
+
+func TestResolveScriptPath(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"tool.v1.ts", "tool.v2.ts", "tool.v10.mjs", "toolbox.v3.ts", "tool.vx.ts", "pinned@2.ts"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tool := filepath.Join(dir, "tool")
+	tests := []struct {
+		arg, want string
+		wantErr   bool
+	}{
+		{arg: tool + "@latest", want: tool + ".v10.mjs"},
+		{arg: tool + "@2", want: tool + ".v2.ts"},
+		{arg: tool + "@5", wantErr: true},
+		{arg: filepath.Join(dir, "missing") + "@latest", wantErr: true},
+		{arg: filepath.Join(dir, "pinned@2.ts"), want: filepath.Join(dir, "pinned@2.ts")},
+		{arg: filepath.Join(dir, "tool.v1.ts"), want: filepath.Join(dir, "tool.v1.ts")},
+		{arg: tool + "@beta", want: tool + "@beta"},
+	}
+	for _, tt := range tests {
+		got, err := resolveScriptPath(tt.arg)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("resolveScriptPath(%q) = %q, %v; want %q (error %v)", tt.arg, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestFindInstallWarning(t *testing.T) {
+	tests := []struct {
+		output, want string
+	}{
+		{"bun install v1.1.0\n + zod@3.23.8\n 1 package installed\n", ""},
+		{"bun install v1.1.0\nwarn: incorrect peer dependency \"react@17\"\n", "warn: incorrect peer dependency \"react@17\""},
+		{"  npm WARN deprecated request@2.88.2: request has been deprecated\n", "npm WARN deprecated request@2.88.2: request has been deprecated"},
+		{"Saved lockfile\n", ""},
+	}
+	for _, tt := range tests {
+		got, err := findInstallWarning(tt.output, defaultInstallWarningPatterns)
+		if err != nil || got != tt.want {
+			t.Errorf("findInstallWarning(%q) = %q, %v; want %q", tt.output, got, err, tt.want)
+		}
+	}
+	if _, err := findInstallWarning("", []string{"("}); err == nil {
+		t.Error("invalid pattern was not an error")
+	}
+}
+
+// writeNodeModules creates a package.json for each name@version under a
+// temporary node_modules and returns its path.
+func writeNodeModules(t *testing.T, versions map[string]string) string {
+	t.Helper()
+	nodeModules := filepath.Join(t.TempDir(), "node_modules")
+	for name, version := range versions {
+		dir := filepath.Join(nodeModules, name)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		manifest := fmt.Sprintf(`{"name": %q, "version": %q}`, name, version)
+		if err := os.WriteFile(filepath.Join(dir, "package.json"), []byte(manifest), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return nodeModules
+}
+
+func TestInstalledMismatches(t *testing.T) {
+	nodeModules := writeNodeModules(t, map[string]string{"zod": "3.23.8", "@scope/pkg": "1.4.0", "left-pad": "1.3.0"})
+	deps := Dependencies{
+		"zod":        "^3.20.0",
+		"@scope/pkg": "~1.3.0",
+		"left-pad":   "latest",
+		"chalk":      "5",
+	}
+	got := installedMismatches(deps, nodeModules)
+	want := []string{"installed @scope/pkg@1.4.0 does not satisfy ~1.3.0", "chalk is not installed"}
+	if !slices.Equal(got, want) {
+		t.Errorf("installedMismatches = %q, want %q", got, want)
+	}
+}
+
+func TestUninstalledPackages(t *testing.T) {
+	// A cache entry with only the implicit @types/node must still be
+	// installed
+	empty := filepath.Join(t.TempDir(), "node_modules")
+	if got := uninstalledPackages(Dependencies{"@types/node": "latest"}, empty); !slices.Equal(got, []string{"@types/node"}) {
+		t.Errorf("uninstalledPackages = %q, want [@types/node]", got)
+	}
+	nodeModules := writeNodeModules(t, map[string]string{"@types/node": "22.0.0"})
+	if got := uninstalledPackages(Dependencies{"@types/node": "latest", "zod": "3"}, nodeModules); !slices.Equal(got, []string{"zod"}) {
+		t.Errorf("uninstalledPackages = %q, want [zod]", got)
+	}
+}
+
+func TestTypesOnly(t *testing.T) {
+	deps := Dependencies{"@types/node": "22", "@types/react": "18", "react": "18", "@typescript/vfs": "1"}
+	got := deps.TypesOnly()
+	if fmt.Sprint(got) != fmt.Sprint(Dependencies{"@types/node": "22", "@types/react": "18"}) {
+		t.Errorf("TypesOnly() = %v", got)
+	}
+}
+
+func TestNormalizeDependencyNames(t *testing.T) {
+	deps := map[string]any{"React": "18", "react": "17", "Zod": "3", "left-pad": "1"}
+	normalizeDependencyNames(deps, "dependencies", "s.ts")
+	want := map[string]any{"react": "17", "zod": "3", "left-pad": "1"}
+	if fmt.Sprint(deps) != fmt.Sprint(want) {
+		t.Errorf("got %v, want %v", deps, want)
+	}
+}
+
+func TestInsertBlock(t *testing.T) {
+	block := "// /// script\n// {}\n// ///\n"
+	tests := []struct {
+		name, content, want string
+	}{
+		{"empty", "", block},
+		{"no shebang", "console.log(1)\n", block + "\nconsole.log(1)\n"},
+		{"shebang", "#!/usr/bin/env -S bunv run --\nconsole.log(1)\n", "#!/usr/bin/env -S bunv run --\n" + block + "\nconsole.log(1)\n"},
+		{"shebang only", "#!/usr/bin/env -S bunv run --", "#!/usr/bin/env -S bunv run --\n" + block},
+	}
+	for _, tt := range tests {
+		if got := insertBlock(tt.content, block, shebangLines(tt.content)); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRemoveCommand(t *testing.T) {
+	script := writeScript(t, "#!/usr/bin/env -S bunv run --\n// /// script\n// {\n//   \"dependencies\": {\n//     \"Zod\": \"3\",\n//     \"left-pad\": \"1.3.0\"\n//   }\n// }\n// ///\nconsole.log(1)\n")
+	removeCmd.Flags().Set("script", script)
+	defer removeCmd.Flags().Set("script", "")
+	removeCmd.Run(removeCmd, []string{"zod@3"})
+
+	data, err := os.ReadFile(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "#!/usr/bin/env -S bunv run --\n// /// script\n// {\n//   \"dependencies\": {\n//     \"left-pad\": \"1.3.0\"\n//   }\n// }\n// ///\nconsole.log(1)\n"
+	if string(data) != want {
+		t.Errorf("script is now:\n%s\nwant:\n%s", data, want)
+	}
+}
+
+func TestAddCommandKeepsShebangFirst(t *testing.T) {
+	for _, flag := range []string{"before-line", "top"} {
+		script := writeScript(t, "#!/usr/bin/env -S bunv run --\nconsole.log(1)\n")
+		addCmd.Flags().Set("script", script)
+		value := "1"
+		if flag == "top" {
+			value = "true"
+		}
+		addCmd.Flags().Set(flag, value)
+		addCmd.Run(addCmd, []string{"left-pad@1.3.0"})
+		addCmd.Flags().Set("script", "")
+		addCmd.Flags().Lookup(flag).Value.Set(addCmd.Flags().Lookup(flag).DefValue)
+		addCmd.Flags().Lookup(flag).Changed = false
+
+		data, err := os.ReadFile(script)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(string(data), "#!/usr/bin/env -S bunv run --\n// /// script\n") {
+			t.Errorf("--%s: script is now:\n%s", flag, data)
+		}
+	}
+}
+
+func TestPrintPlan(t *testing.T) {
+	deps := Dependencies{"zod": "3.23.8", "left-pad": "^1.3.0"}
+	var cold, warm strings.Builder
+	printPlan(&cold, deps, "/cache/abc", true)
+	printPlan(&warm, deps, "/cache/abc", false)
+
+	want := "Install plan:\n  left-pad@^1.3.0\n  zod@3.23.8\nCache directory: /cache/abc\nCache: cold (new install)\nCommand: bun install\n"
+	if cold.String() != want {
+		t.Errorf("cold plan:\n%s\nwant:\n%s", cold.String(), want)
+	}
+	if !strings.HasSuffix(warm.String(), "Cache: warm (reusing existing install)\n") || strings.Contains(warm.String(), "Command:") {
+		t.Errorf("warm plan:\n%s", warm.String())
+	}
+}
+
+func TestConfirm(t *testing.T) {
+	defer func(stdin *os.File) { os.Stdin = stdin }(os.Stdin)
+	for answer, want := range map[string]bool{"y\n": true, "YES\n": true, " y \n": true, "n\n": false, "\n": false, "": false, "yep\n": false} {
+		path := filepath.Join(t.TempDir(), "stdin")
+		if err := os.WriteFile(path, []byte(answer), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		os.Stdin = f
+		if got := confirm("Proceed?"); got != want {
+			t.Errorf("confirm with answer %q = %v, want %v", answer, got, want)
+		}
+		f.Close()
+	}
+}
+
+func TestCheckDeterministic(t *testing.T) {
+	cacheDir := t.TempDir()
+	if err := checkDeterministic(Dependencies{"zod": "3.23.8"}, cacheDir); err != nil {
+		t.Errorf("exact versions rejected: %v", err)
+	}
+	err := checkDeterministic(Dependencies{"zod": "3.23.8", "left-pad": "^1.3.0", "@types/node": "latest"}, cacheDir)
+	if err == nil || !strings.Contains(err.Error(), "@types/node@latest, left-pad@^1.3.0") {
+		t.Errorf("loose versions without a lockfile: error = %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(cacheDir, "bun.lock"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkDeterministic(Dependencies{"left-pad": "^1.3.0"}, cacheDir); err != nil {
+		t.Errorf("loose versions with a lockfile rejected: %v", err)
+	}
+}
+
+func TestInstallArgv(t *testing.T) {
+	cacheDir := t.TempDir()
+	defer func(args []string) { installArgs = args }(installArgs)
+	installArgs = []string{"--production --ignore-scripts", "--verbose", `--registry "https://npm.example.com/a b"`}
+	want := []string{"install", "--production", "--ignore-scripts", "--verbose", "--registry", "https://npm.example.com/a b"}
+	if got := installArgv(cacheDir); !slices.Equal(got, want) {
+		t.Errorf("installArgv = %q, want %q", got, want)
+	}
+
+	defer func(f bool) { frozen = f }(frozen)
+	frozen = true
+	if got := installArgv(cacheDir); slices.Contains(got, "--frozen-lockfile") {
+		t.Errorf("installArgv = %q, frozen without a lockfile", got)
+	}
+	if err := os.WriteFile(filepath.Join(cacheDir, "bun.lock"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got := installArgv(cacheDir); !slices.Contains(got, "--frozen-lockfile") {
+		t.Errorf("installArgv = %q, want --frozen-lockfile with a lockfile", got)
+	}
+}
+
+func TestTreeHashFollowsLockfile(t *testing.T) {
+	dir := t.TempDir()
+	deps := Dependencies{"left-pad": "^1.3.0"}
+	unlocked := treeHash(deps, dir)
+
+	lockfile := filepath.Join(dir, "bun.lock")
+	if err := os.WriteFile(lockfile, []byte(`{"packages": {"left-pad": ["left-pad@1.3.0"]}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	locked := treeHash(deps, dir)
+	if locked == unlocked {
+		t.Error("treeHash didn't change when a lockfile was added")
+	}
+	if again := treeHash(deps, dir); again != locked {
+		t.Errorf("treeHash = %s, then %s for the same tree", locked, again)
+	}
+
+	if err := os.WriteFile(lockfile, []byte(`{"packages": {"left-pad": ["left-pad@1.3.1"]}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if relocked := treeHash(deps, dir); relocked == locked {
+		t.Error("treeHash didn't change when the lockfile changed")
+	}
+	if other := treeHash(Dependencies{"left-pad": "^1.3.1"}, dir); other == treeHash(deps, dir) {
+		t.Error("treeHash didn't change when the dependencies changed")
+	}
+}
@@ -0,0 +1,138 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// writeFakeBun writes a shell script standing in for bun that records its
+// arguments in args.txt, prints output and exits with status.
+func writeFakeBun(t *testing.T, output string, status int) (bunPath, argsPath string) {
+	t.Helper()
+	dir := t.TempDir()
+	bunPath = filepath.Join(dir, "bun")
+	argsPath = filepath.Join(dir, "args.txt")
+	outputPath := filepath.Join(dir, "output.txt")
+	if err := os.WriteFile(outputPath, []byte(output), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	script := fmt.Sprintf("#!/bin/sh\nprintf '%%s\\n' \"$@\" > %q\ncat %q\nexit %d\n", argsPath, outputPath, status)
+	if err := os.WriteFile(bunPath, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return bunPath, argsPath
+}
+
+func TestFindMissingTypes(t *testing.T) {
+	bunPath, argsPath := writeFakeBun(t, "s.ts(1,17): error TS7016: Could not find a declaration file for module 'left-pad'.", 2)
+	missing, err := findMissingTypes(bunPath, t.TempDir(), "s.ts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(missing, []string{"left-pad"}) {
+		t.Errorf("missing = %q", missing)
+	}
+	args, _ := os.ReadFile(argsPath)
+	if !strings.HasPrefix(string(args), "x\n--package\ntypescript\ntsc\n") {
+		t.Errorf("bun ran with %q, want bun x --package typescript tsc ...", args)
+	}
+
+	bunPath, _ = writeFakeBun(t, "", 1)
+	if _, err := findMissingTypes(bunPath, t.TempDir(), "s.ts"); err == nil {
+		t.Error("tsc exiting non-zero without diagnostics was not an error")
+	}
+}
+
+func TestExitStatus(t *testing.T) {
+	tests := []struct {
+		script string
+		code   int
+		sig    syscall.Signal
+	}{
+		{"exit 0", 0, 0},
+		{"exit 3", 3, 0},
+		{"kill -SEGV $$", 128 + int(syscall.SIGSEGV), syscall.SIGSEGV},
+	}
+	for _, tt := range tests {
+		cmd := exec.Command("sh", "-c", tt.script)
+		cmd.Run()
+		if code, sig := exitStatus(cmd.ProcessState); code != tt.code || sig != tt.sig {
+			t.Errorf("%q: exitStatus = %d, %v; want %d, %v", tt.script, code, sig, tt.code, tt.sig)
+		}
+	}
+}
+
+func TestRunBunRelaysSignals(t *testing.T) {
+	stdin, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdin.Close()
+	ready := filepath.Join(t.TempDir(), "ready")
+	script := fmt.Sprintf("trap 'exit 7' TERM; touch %q; while :; do sleep 0.05; done", ready)
+
+	type result struct {
+		state *os.ProcessState
+		err   error
+	}
+	done := make(chan result)
+	go func() {
+		state, err := runBun("sh", []string{"-c", script}, os.Environ(), stdin)
+		done <- result{state, err}
+	}()
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		if _, err := os.Stat(ready); err == nil {
+			break
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("child never started")
+		}
+	}
+
+	syscall.Kill(os.Getpid(), syscall.SIGTERM)
+	select {
+	case r := <-done:
+		if r.err != nil {
+			t.Fatal(r.err)
+		}
+		if code, _ := exitStatus(r.state); code != 7 {
+			t.Errorf("child exited with %d, want 7 from its TERM trap", code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("SIGTERM was not relayed to the child")
+	}
+	relayedSignal.Store(false)
+}
+
+func TestDryRunLeavesCacheUntouched(t *testing.T) {
+	bunPath, _ := writeFakeBun(t, "1.1.0\n", 0)
+	cacheRoot := filepath.Join(t.TempDir(), "cache")
+	t.Setenv("BUNV_BUN", bunPath)
+	t.Setenv("BUNV_CACHE_DIR", cacheRoot)
+	dryRun = true
+	defer func() { dryRun = false }()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.WriteString("console.log(1)\n")
+	w.Close()
+	stdin := os.Stdin
+	os.Stdin = r
+	defer func() { os.Stdin = stdin }()
+
+	runCmd.Run(runCmd, []string{"-"})
+	if _, err := os.Stat(cacheRoot); !os.IsNotExist(err) {
+		t.Errorf("--dry-run created %s", cacheRoot)
+	}
+}
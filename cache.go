@@ -0,0 +1,585 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Manage the bunv dependency cache",
+}
+
+var cacheExportCmd = &cobra.Command{
+	Use:   "export <file.tar.gz>",
+	Short: "Archive the cache (or a single entry) into a tarball",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		hash, _ := cmd.Flags().GetString("hash")
+		if hash != "" && !isCacheHash(hash) {
+			fmt.Fprintf(os.Stderr, "Error: Invalid --hash %q\n", hash)
+			os.Exit(1)
+		}
+		level, _ := cmd.Flags().GetInt("compression-level")
+		if level < gzip.BestSpeed || level > gzip.BestCompression {
+			fmt.Fprintf(os.Stderr, "Error: --compression-level must be between %d and %d\n", gzip.BestSpeed, gzip.BestCompression)
+			os.Exit(1)
+		}
+		root := getCacheRoot()
+
+		entries, err := cacheEntries(root, hash)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading cache: %v\n", err)
+			os.Exit(1)
+		}
+		if len(entries) == 0 {
+			fmt.Fprintf(os.Stderr, "Error: No cache entries to export\n")
+			os.Exit(1)
+		}
+
+		if err := exportCache(args[0], root, entries, level); err != nil {
+			fmt.Fprintf(os.Stderr, "Error exporting cache: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Exported %d cache entries to %s\n", len(entries), args[0])
+	},
+}
+
+var cacheImportCmd = &cobra.Command{
+	Use:   "import <file.tar.gz>",
+	Short: "Restore cache entries from a tarball",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		hash, _ := cmd.Flags().GetString("hash")
+		if hash != "" && !isCacheHash(hash) {
+			fmt.Fprintf(os.Stderr, "Error: Invalid --hash %q\n", hash)
+			os.Exit(1)
+		}
+		root := getCacheRoot()
+		if err := os.MkdirAll(root, 0755); err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating cache directory: %v\n", err)
+			os.Exit(1)
+		}
+
+		imported, err := importCache(args[0], root, hash)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error importing cache: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Imported %d cache entries into %s\n", imported, root)
+	},
+}
+
+var cacheLockInfoCmd = &cobra.Command{
+	Use:   "lock-info",
+	Short: "List install locks held under the cache root",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		breakStale, _ := cmd.Flags().GetBool("break")
+		locks, err := listInstallLocks(getCacheRoot())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading cache: %v\n", err)
+			os.Exit(1)
+		}
+		if len(locks) == 0 {
+			fmt.Println("No install locks held")
+			return
+		}
+
+		hashes := make([]string, 0, len(locks))
+		for hash := range locks {
+			hashes = append(hashes, hash)
+		}
+		sort.Strings(hashes)
+		for _, hash := range hashes {
+			path := locks[hash]
+			release, held, err := probeInstallLock(path)
+			if os.IsNotExist(err) {
+				// Released while we were listing
+				continue
+			} else if err != nil {
+				fmt.Fprintf(os.Stderr, "Error checking lock %s: %v\n", path, err)
+				os.Exit(1)
+			}
+			lock, err := readInstallLock(path)
+			switch {
+			case held && err != nil:
+				fmt.Printf("%s  held, unreadable lock (%v)\n", hash, err)
+			case held:
+				fmt.Printf("%s  pid %d  held %s\n", hash, lock.PID, time.Since(lock.AcquiredAt).Round(time.Second))
+			case err != nil:
+				fmt.Printf("%s  not held, unreadable lock (%v)\n", hash, err)
+			default:
+				fmt.Printf("%s  pid %d (not held)  acquired %s ago\n", hash, lock.PID, time.Since(lock.AcquiredAt).Round(time.Second))
+			}
+			if held {
+				continue
+			}
+			if err := release(breakStale); err != nil {
+				fmt.Fprintf(os.Stderr, "Error removing lock %s: %v\n", path, err)
+				os.Exit(1)
+			}
+			if breakStale {
+				fmt.Printf("%s  lock released\n", hash)
+			}
+		}
+	},
+}
+
+var cachePathCmd = &cobra.Command{
+	Use:   "path <script.ts>",
+	Short: "Print the cache directory a script's dependencies are installed in",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		applyConfig(cmd)
+		scriptFile := args[0]
+		if _, err := os.Stat(scriptFile); os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "Error: File %s does not exist\n", scriptFile)
+			os.Exit(1)
+		}
+		installRegistry, err := scriptRegistry(scriptFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		aliases, err := scriptAliases(scriptFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		deps := getDependencies(scriptFile, defaultEngine)
+		cacheDir, err := filepath.Abs(getCacheDir(deps.CacheKey(installedBunVersion(), installRegistry, aliases)))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error resolving cache directory: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(cacheDir)
+	},
+}
+
+var cacheSizeCmd = &cobra.Command{
+	Use:   "size",
+	Short: "Print the number of cache entries and their total size",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		root := getCacheRoot()
+		entries, err := cacheEntries(root, "")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading cache: %v\n", err)
+			os.Exit(1)
+		}
+		var total int64
+		for _, entry := range entries {
+			total += dirSize(filepath.Join(root, entry))
+		}
+		fmt.Printf("%d cache entries, %s in %s\n", len(entries), formatBytes(total), root)
+	},
+}
+
+var cleanCmd = &cobra.Command{
+	Use:   "clean",
+	Short: "Remove cached dependency installs",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		olderThan, _ := cmd.Flags().GetString("older-than")
+		var maxAge time.Duration
+		if olderThan != "" {
+			var err error
+			if maxAge, err = parseAge(olderThan); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: Invalid --older-than: %v\n", err)
+				os.Exit(1)
+			}
+		}
+
+		root := filepath.Clean(getCacheRoot())
+		if root == "" || root == "." || root == string(filepath.Separator) {
+			fmt.Fprintf(os.Stderr, "Error: Refusing to clean cache root %q\n", root)
+			os.Exit(1)
+		}
+		entries, err := cacheEntries(root, "")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading cache: %v\n", err)
+			os.Exit(1)
+		}
+
+		removed := 0
+		var reclaimed int64
+		for _, entry := range entries {
+			path := filepath.Join(root, entry)
+			info, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+			if maxAge > 0 && time.Since(info.ModTime()) < maxAge {
+				continue
+			}
+			// Holding the install lock keeps a concurrent install out of
+			// the entry while it is removed
+			release, err := tryInstallLock(path)
+			if err == errLockHeld {
+				fmt.Fprintf(os.Stderr, "Warning: Skipping %s, which another bunv process is installing\n", path)
+				continue
+			} else if err != nil {
+				fmt.Fprintf(os.Stderr, "Error locking %s: %v\n", path, err)
+				continue
+			}
+			size := dirSize(path)
+			if dryRun {
+				fmt.Printf("Would remove %s (%s)\n", path, formatBytes(size))
+			} else if err := os.RemoveAll(path); err != nil {
+				release()
+				fmt.Fprintf(os.Stderr, "Error removing %s: %v\n", path, err)
+				continue
+			}
+			release()
+			removed++
+			reclaimed += size
+		}
+		if dryRun {
+			fmt.Printf("Would remove %d cache entries, reclaiming %s\n", removed, formatBytes(reclaimed))
+		} else {
+			fmt.Printf("Removed %d cache entries, reclaimed %s\n", removed, formatBytes(reclaimed))
+		}
+	},
+}
+
+// parseAge parses a duration as time.ParseDuration does, or a number of days such as "7d".
+func parseAge(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("%q is not a duration", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// dirSize returns the total size of the regular files under path.
+func dirSize(path string) int64 {
+	var size int64
+	filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// formatBytes renders n in the largest binary unit that keeps it at least 1.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// isCacheHash reports whether hash names a cache entry: hex digits, with a "types-" prefix for types-only entries.
+func isCacheHash(hash string) bool {
+	hash = strings.TrimPrefix(hash, "types-")
+	if hash == "" {
+		return false
+	}
+	for _, c := range hash {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// cacheEntries lists the hash directories under root, or just the given hash.
+func cacheEntries(root, hash string) ([]string, error) {
+	if hash != "" {
+		if _, err := os.Stat(filepath.Join(root, hash)); err != nil {
+			return nil, err
+		}
+		return []string{hash}, nil
+	}
+	dirEntries, err := os.ReadDir(root)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []string
+	for _, e := range dirEntries {
+		if e.IsDir() && !strings.HasPrefix(e.Name(), ".") {
+			entries = append(entries, e.Name())
+		}
+	}
+	return entries, nil
+}
+
+func exportCache(archivePath, root string, entries []string, level int) error {
+	f, err := os.Create(archivePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz, err := gzip.NewWriterLevel(f, level)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(gz)
+
+	for _, entry := range entries {
+		err := filepath.Walk(filepath.Join(root, entry), func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(root, path)
+			if err != nil {
+				return err
+			}
+			link := ""
+			if info.Mode()&os.ModeSymlink != 0 {
+				if link, err = os.Readlink(path); err != nil {
+					return err
+				}
+			}
+			hdr, err := tar.FileInfoHeader(info, link)
+			if err != nil {
+				return err
+			}
+			hdr.Name = filepath.ToSlash(rel)
+			if err := tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+			src, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer src.Close()
+			_, err = io.Copy(tw, src)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// importCache extracts the archive under root and moves each valid entry into place.
+func importCache(archivePath, root, hash string) (int, error) {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return 0, err
+	}
+	defer gz.Close()
+
+	staging, err := os.MkdirTemp(root, ".import-")
+	if err != nil {
+		return 0, err
+	}
+	defer os.RemoveAll(staging)
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		name := filepath.Clean(filepath.FromSlash(hdr.Name))
+		if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+			return 0, fmt.Errorf("invalid path in archive: %s", hdr.Name)
+		}
+		if hash != "" && strings.SplitN(name, string(filepath.Separator), 2)[0] != hash {
+			continue
+		}
+		target := filepath.Join(staging, name)
+		if err := checkNoSymlinks(staging, name); err != nil {
+			return 0, err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return 0, err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return 0, err
+			}
+			out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(hdr.Mode).Perm())
+			if err != nil {
+				return 0, err
+			}
+			if _, err := io.Copy(out, tr); err != nil {
+				out.Close()
+				return 0, err
+			}
+			if err := out.Close(); err != nil {
+				return 0, err
+			}
+		case tar.TypeSymlink:
+			if err := checkSymlinkTarget(name, hdr.Linkname); err != nil {
+				return 0, err
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return 0, err
+			}
+			if err := os.Symlink(hdr.Linkname, target); err != nil {
+				return 0, err
+			}
+		case tar.TypeLink:
+			return 0, fmt.Errorf("hard link in archive not supported: %s", hdr.Name)
+		}
+	}
+
+	entries, err := cacheEntries(staging, "")
+	if err != nil {
+		return 0, err
+	}
+	imported := 0
+	for _, entry := range entries {
+		// Links that are checked one at a time can still escape together,
+		// such as one that climbs out through another
+		if err := checkEntrySymlinks(filepath.Join(staging, entry)); err != nil {
+			return 0, err
+		}
+	}
+	for _, entry := range entries {
+		if err := validateCacheEntry(filepath.Join(staging, entry)); err != nil {
+			fmt.Fprintf(os.Stderr, "Skipping %s: %v\n", entry, err)
+			continue
+		}
+		dest := filepath.Join(root, entry)
+		if _, err := os.Stat(dest); err == nil {
+			fmt.Fprintf(os.Stderr, "Skipping %s: already cached\n", entry)
+			continue
+		}
+		if err := os.Rename(filepath.Join(staging, entry), dest); err != nil {
+			return imported, err
+		}
+		imported++
+	}
+	return imported, nil
+}
+
+// checkNoSymlinks returns an error if any existing component of name under dir is a symlink.
+func checkNoSymlinks(dir, name string) error {
+	path := dir
+	for _, part := range strings.Split(name, string(filepath.Separator)) {
+		path = filepath.Join(path, part)
+		info, err := os.Lstat(path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("invalid path in archive: %s is inside a symlink", name)
+		}
+	}
+	return nil
+}
+
+// checkSymlinkTarget returns an error unless the symlink name points inside its cache entry.
+func checkSymlinkTarget(name, target string) error {
+	if filepath.IsAbs(target) {
+		return fmt.Errorf("invalid symlink in archive: %s -> %s is absolute", name, target)
+	}
+	entry := strings.SplitN(name, string(filepath.Separator), 2)[0]
+	resolved := filepath.Join(filepath.Dir(name), filepath.FromSlash(target))
+	if resolved != entry && !strings.HasPrefix(resolved, entry+string(filepath.Separator)) {
+		return fmt.Errorf("invalid symlink in archive: %s -> %s points outside %s", name, target, entry)
+	}
+	return nil
+}
+
+// checkEntrySymlinks returns an error if a symlink under dir resolves to a path outside it.
+func checkEntrySymlinks(dir string) error {
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.Mode()&os.ModeSymlink == 0 {
+			return err
+		}
+		resolved, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			// Dangling; its target was already checked on extraction
+			return nil
+		}
+		if resolved != realDir && !strings.HasPrefix(resolved, realDir+string(filepath.Separator)) {
+			rel, _ := filepath.Rel(filepath.Dir(dir), path)
+			return fmt.Errorf("invalid symlink in archive: %s resolves outside its cache entry", rel)
+		}
+		return nil
+	})
+}
+
+// validateCacheEntry checks that dir holds a parseable package.json.
+func validateCacheEntry(dir string) error {
+	data, err := os.ReadFile(filepath.Join(dir, "package.json"))
+	if err != nil {
+		return fmt.Errorf("missing package.json")
+	}
+	var pkg map[string]any
+	if err := json.Unmarshal(data, &pkg); err != nil {
+		return fmt.Errorf("invalid package.json: %v", err)
+	}
+	return nil
+}
+
+func init() {
+	cacheExportCmd.Flags().String("hash", "", "Export only the cache entry with this hash")
+	cacheExportCmd.Flags().Int("compression-level", 6, "gzip compression level, from 1 (fastest) to 9 (smallest)")
+	cacheImportCmd.Flags().String("hash", "", "Import only the cache entry with this hash")
+	cacheCmd.AddCommand(cacheExportCmd)
+	cacheCmd.AddCommand(cacheImportCmd)
+	cacheLockInfoCmd.Flags().Bool("break", false, "Remove locks that no process holds")
+	cacheCmd.AddCommand(cacheLockInfoCmd)
+	cachePathCmd.Flags().StringSliceVar(&withPackages, "with", []string{}, "Packages to include as 'bunv run --with' would")
+	cachePathCmd.Flags().BoolVar(&withOverride, "with-override", false, "Let --with versions take precedence over the header, as for 'bunv run'")
+	cachePathCmd.Flags().BoolVar(&noTypes, "no-types", false, "Leave out @types/node as 'bunv run --no-types' would")
+	cachePathCmd.Flags().StringVar(&typesNodeVersion, "types-node-version", "", "Version of @types/node, as for 'bunv run'")
+	cachePathCmd.Flags().StringVar(&registry, "registry", "", "Registry URL, as for 'bunv run'")
+	cacheCmd.AddCommand(cachePathCmd)
+	cacheCmd.AddCommand(cacheSizeCmd)
+	rootCmd.AddCommand(cacheCmd)
+	cleanCmd.Flags().Bool("dry-run", false, "List the entries that would be removed and their total size without removing them")
+	cleanCmd.Flags().String("older-than", "", "Only remove entries last modified longer ago than this, e.g. 7d or 12h")
+	rootCmd.AddCommand(cleanCmd)
+}
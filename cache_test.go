@@ -0,0 +1,253 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExportImportRoundTrip(t *testing.T) {
+	src := t.TempDir()
+	entry := filepath.Join(src, "abcd1234")
+	files := map[string]string{
+		"package.json":                    `{"dependencies": {"zod": "3.0.0"}}`,
+		"node_modules/zod/package.json":   `{"name": "zod", "version": "3.0.0"}`,
+		"node_modules/zod/bin/cli.js":     "console.log(1)\n",
+		"node_modules/.cache/nested/file": "x",
+	}
+	for name, content := range files {
+		path := filepath.Join(entry, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(entry, "node_modules", ".bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("../zod/bin/cli.js", filepath.Join(entry, "node_modules", ".bin", "zod")); err != nil {
+		t.Fatal(err)
+	}
+
+	archive := filepath.Join(t.TempDir(), "cache.tar.gz")
+	if err := exportCache(archive, src, []string{"abcd1234"}, gzip.DefaultCompression); err != nil {
+		t.Fatalf("exportCache: %v", err)
+	}
+	dst := t.TempDir()
+	imported, err := importCache(archive, dst, "")
+	if err != nil {
+		t.Fatalf("importCache: %v", err)
+	}
+	if imported != 1 {
+		t.Fatalf("imported %d entries, want 1", imported)
+	}
+	for name, want := range files {
+		got, err := os.ReadFile(filepath.Join(dst, "abcd1234", name))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+		} else if string(got) != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	if got, err := os.ReadFile(filepath.Join(dst, "abcd1234", "node_modules", ".bin", "zod")); err != nil || string(got) != files["node_modules/zod/bin/cli.js"] {
+		t.Errorf("node_modules/.bin/zod = %q, %v; want the linked cli.js", got, err)
+	}
+
+	// Importing again leaves the existing entry alone
+	if imported, err := importCache(archive, dst, ""); err != nil || imported != 0 {
+		t.Errorf("second import = %d, %v; want 0, nil", imported, err)
+	}
+}
+
+// tarEntry is one member of a test archive.
+type tarEntry struct {
+	name     string
+	typeflag byte
+	linkname string
+	content  string
+}
+
+func writeTestArchive(t *testing.T, entries []tarEntry) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "archive.tar.gz")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Typeflag: e.typeflag, Linkname: e.linkname, Mode: 0644, Size: int64(len(e.content))}
+		if e.typeflag == tar.TypeDir {
+			hdr.Mode = 0755
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestImportRejectsMaliciousArchives(t *testing.T) {
+	outside := t.TempDir()
+	pkg := tarEntry{name: "abcd/package.json", typeflag: tar.TypeReg, content: "{}"}
+	tests := []struct {
+		name    string
+		entries []tarEntry
+		wantErr string
+	}{
+		{"absolute symlink then write through it", []tarEntry{
+			pkg,
+			{name: "abcd/esc", typeflag: tar.TypeSymlink, linkname: outside},
+			{name: "abcd/esc/pwned.txt", typeflag: tar.TypeReg, content: "pwned"},
+		}, "absolute"},
+		{"relative symlink out of the entry", []tarEntry{
+			pkg,
+			{name: "abcd/esc", typeflag: tar.TypeSymlink, linkname: "../../../../../../../../.." + outside},
+		}, "points outside"},
+		{"symlink to a sibling entry", []tarEntry{
+			pkg,
+			{name: "abcd/esc", typeflag: tar.TypeSymlink, linkname: "../efgh"},
+		}, "points outside"},
+		{"write through a relative symlink", []tarEntry{
+			pkg,
+			{name: "abcd/dir", typeflag: tar.TypeDir},
+			{name: "abcd/link", typeflag: tar.TypeSymlink, linkname: "dir"},
+			{name: "abcd/link/file", typeflag: tar.TypeReg, content: "x"},
+		}, "inside a symlink"},
+		{"overwrite a symlink", []tarEntry{
+			pkg,
+			{name: "abcd/link", typeflag: tar.TypeSymlink, linkname: "package.json"},
+			{name: "abcd/link", typeflag: tar.TypeReg, content: "x"},
+		}, "inside a symlink"},
+		{"symlinks that escape together", []tarEntry{
+			pkg,
+			{name: "abcd/here", typeflag: tar.TypeSymlink, linkname: "."},
+			{name: "abcd/up", typeflag: tar.TypeSymlink, linkname: "here/.."},
+		}, "resolves outside"},
+		{"hard link", []tarEntry{
+			pkg,
+			{name: "abcd/hard", typeflag: tar.TypeLink, linkname: "abcd/package.json"},
+		}, "hard link"},
+		{"path traversal", []tarEntry{
+			{name: "../pwned.txt", typeflag: tar.TypeReg, content: "pwned"},
+		}, "invalid path"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			_, err := importCache(writeTestArchive(t, tt.entries), root, "")
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("importCache error = %v, want one containing %q", err, tt.wantErr)
+			}
+			if _, err := os.Stat(filepath.Join(root, "abcd")); !os.IsNotExist(err) {
+				t.Errorf("entry was imported despite the error")
+			}
+			if matches, _ := filepath.Glob(filepath.Join(outside, "*")); len(matches) > 0 {
+				t.Errorf("files written outside the cache: %v", matches)
+			}
+		})
+	}
+}
+
+func TestExportCompressionLevels(t *testing.T) {
+	src := t.TempDir()
+	entry := filepath.Join(src, "abcd1234", "node_modules", "pkg")
+	if err := os.MkdirAll(entry, 0755); err != nil {
+		t.Fatal(err)
+	}
+	// Compressible, but not trivially so, for the levels to differ
+	var content strings.Builder
+	for i := range 20000 {
+		fmt.Fprintf(&content, "export const value%d = %d * %d;\n", i, i*i%977, i%13)
+	}
+	if err := os.WriteFile(filepath.Join(entry, "index.js"), []byte(content.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "abcd1234", "package.json"), []byte(`{"dependencies": {"pkg": "1.0.0"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	sizes := map[int]int64{}
+	for _, level := range []int{gzip.BestSpeed, gzip.BestCompression} {
+		archive := filepath.Join(t.TempDir(), "cache.tar.gz")
+		if err := exportCache(archive, src, []string{"abcd1234"}, level); err != nil {
+			t.Fatalf("level %d: %v", level, err)
+		}
+		info, err := os.Stat(archive)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sizes[level] = info.Size()
+
+		dst := t.TempDir()
+		if _, err := importCache(archive, dst, ""); err != nil {
+			t.Fatalf("level %d: importCache: %v", level, err)
+		}
+		got, err := os.ReadFile(filepath.Join(dst, "abcd1234", "node_modules", "pkg", "index.js"))
+		if err != nil || string(got) != content.String() {
+			t.Errorf("level %d: archive did not decompress to the original content (%v)", level, err)
+		}
+	}
+	if sizes[gzip.BestCompression] >= sizes[gzip.BestSpeed] {
+		t.Errorf("level %d archive is %d bytes, not smaller than level %d's %d bytes",
+			gzip.BestCompression, sizes[gzip.BestCompression], gzip.BestSpeed, sizes[gzip.BestSpeed])
+	}
+}
+
+func TestIsCacheHash(t *testing.T) {
+	for hash, want := range map[string]bool{
+		"0123456789abcdef":       true,
+		"types-0123456789abcdef": true,
+		"":                       false,
+		"types-":                 false,
+		"../x":                   false,
+		"abc/def":                false,
+		"ABCDEF":                 false,
+		"types-../abc":           false,
+	} {
+		if got := isCacheHash(hash); got != want {
+			t.Errorf("isCacheHash(%q) = %v, want %v", hash, got, want)
+		}
+	}
+}
+
+func TestCleanSkipsLockedEntries(t *testing.T) {
+	root := t.TempDir()
+	t.Setenv("BUNV_CACHE_DIR", root)
+	for _, entry := range []string{"aaaa", "bbbb"} {
+		if err := os.MkdirAll(filepath.Join(root, entry, "node_modules"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	release, err := acquireInstallLock(filepath.Join(root, "bbbb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	cleanCmd.Run(cleanCmd, nil)
+
+	if _, err := os.Stat(filepath.Join(root, "aaaa")); !os.IsNotExist(err) {
+		t.Errorf("unlocked entry not removed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "bbbb", "node_modules")); err != nil {
+		t.Errorf("entry removed while its install lock was held: %v", err)
+	}
+}
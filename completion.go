@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var completionCmd = &cobra.Command{
+	Use:   "completion <bash|zsh|fish|powershell>",
+	Short: "Print a shell completion script",
+	Long: `Print a completion script for the given shell. For example, to load
+completions in the current bash session:
+
+	source <(bunv completion bash)
+
+or, for every zsh session, write it to a directory on your fpath:
+
+	bunv completion zsh > "${fpath[1]}/_bunv"`,
+	Args:      cobra.ExactArgs(1),
+	ValidArgs: []string{"bash", "zsh", "fish", "powershell"},
+	Run: func(cmd *cobra.Command, args []string) {
+		var err error
+		switch args[0] {
+		case "bash":
+			err = rootCmd.GenBashCompletionV2(os.Stdout, true)
+		case "zsh":
+			err = rootCmd.GenZshCompletion(os.Stdout)
+		case "fish":
+			err = rootCmd.GenFishCompletion(os.Stdout, true)
+		case "powershell":
+			err = rootCmd.GenPowerShellCompletionWithDesc(os.Stdout)
+		default:
+			fmt.Fprintf(os.Stderr, "Error: Unsupported shell %q (supported: bash, zsh, fish, powershell)\n", args[0])
+			os.Exit(1)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error generating completion script: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// completeScriptFiles completes file names with one of scriptExtensions.
+func completeScriptFiles(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	exts := make([]string, len(scriptExtensions))
+	for i, ext := range scriptExtensions {
+		exts[i] = strings.TrimPrefix(ext, ".")
+	}
+	return exts, cobra.ShellCompDirectiveFilterFileExt
+}
+
+// recentPackages returns the packages installed in the cache, most recently used first.
+func recentPackages() []string {
+	matches, _ := filepath.Glob(filepath.Join(getCacheRoot(), "*", "package.json"))
+	type entry struct {
+		path    string
+		modTime int64
+	}
+	entries := make([]entry, 0, len(matches))
+	for _, path := range matches {
+		if info, err := os.Stat(filepath.Dir(path)); err == nil {
+			entries = append(entries, entry{path, info.ModTime().UnixNano()})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].modTime > entries[j].modTime })
+
+	var names []string
+	seen := map[string]bool{}
+	for _, e := range entries {
+		data, err := os.ReadFile(e.path)
+		if err != nil {
+			continue
+		}
+		var manifest struct {
+			Dependencies    map[string]string `json:"dependencies"`
+			DevDependencies map[string]string `json:"devDependencies"`
+		}
+		if json.Unmarshal(data, &manifest) != nil {
+			continue
+		}
+		var entryNames []string
+		for name := range manifest.Dependencies {
+			entryNames = append(entryNames, name)
+		}
+		for name := range manifest.DevDependencies {
+			entryNames = append(entryNames, name)
+		}
+		sort.Strings(entryNames)
+		for _, name := range entryNames {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// completeRecentPackages completes package names for 'bunv add'.
+func completeRecentPackages(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	var names []string
+	for _, name := range recentPackages() {
+		if strings.HasPrefix(name, toComplete) && !specsInclude(args, name) {
+			names = append(names, name)
+		}
+	}
+	return names, cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeScriptDependencies completes the --script file's dependencies for 'bunv remove'.
+func completeScriptDependencies(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	scriptFile, _ := cmd.Flags().GetString("script")
+	if scriptFile == "" {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	section := "dependencies"
+	if dev, _ := cmd.Flags().GetBool("dev"); dev {
+		section = "devDependencies"
+	}
+	header, _ := extractHeader(scriptFile)
+	var names []string
+	for name := range headerStringMap(header, section) {
+		if strings.HasPrefix(name, toComplete) && !specsInclude(args, name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, cobra.ShellCompDirectiveNoFileComp
+}
+
+// specsInclude reports whether one of specs names the package name.
+func specsInclude(specs []string, name string) bool {
+	for _, spec := range specs {
+		if specName, _ := parsePackageSpec(spec); specName == name {
+			return true
+		}
+	}
+	return false
+}
+
+// registerCompletions sets up the custom completions.
+func registerCompletions() {
+	for _, cmd := range []*cobra.Command{addCmd, removeCmd, outdatedCmd, upgradeCmd} {
+		cmd.RegisterFlagCompletionFunc("script", completeScriptFiles)
+	}
+	for _, cmd := range []*cobra.Command{initCmd, listCmd, validateCmd, cachePathCmd} {
+		cmd.ValidArgsFunction = completeScriptFiles
+	}
+	runCmd.ValidArgsFunction = func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) == 0 {
+			return completeScriptFiles(cmd, args, toComplete)
+		}
+		// The script's own arguments
+		return nil, cobra.ShellCompDirectiveDefault
+	}
+	addCmd.ValidArgsFunction = completeRecentPackages
+	removeCmd.ValidArgsFunction = completeScriptDependencies
+	configGetCmd.ValidArgs = configKeys
+	configSetCmd.ValidArgsFunction = func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) == 0 {
+			return configKeys, cobra.ShellCompDirectiveNoFileComp
+		}
+		return nil, cobra.ShellCompDirectiveDefault
+	}
+}
+
+func init() {
+	// Replaced by completionCmd, which documents how to load the scripts
+	rootCmd.CompletionOptions.DisableDefaultCmd = true
+	rootCmd.AddCommand(completionCmd)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestCompletionScripts(t *testing.T) {
+	for shell, marker := range map[string]string{
+		"bash":       "complete -o default -F __start_bunv bunv",
+		"zsh":        "#compdef bunv",
+		"fish":       "complete -c bunv",
+		"powershell": "Register-ArgumentCompleter",
+	} {
+		cmd := exec.Command(os.Args[0], "completion", shell)
+		cmd.Env = append(os.Environ(), bunvTestMainEnv+"=1")
+		out, err := cmd.Output()
+		if err != nil {
+			t.Errorf("completion %s: %v", shell, err)
+			continue
+		}
+		if !strings.Contains(string(out), marker) {
+			t.Errorf("completion %s printed %d bytes without %q", shell, len(out), marker)
+		}
+	}
+
+	cmd := exec.Command(os.Args[0], "completion", "tcsh")
+	cmd.Env = append(os.Environ(), bunvTestMainEnv+"=1")
+	if out, err := cmd.CombinedOutput(); err == nil || !strings.Contains(string(out), `Unsupported shell "tcsh"`) {
+		t.Errorf("completion tcsh = %v:\n%s", err, out)
+	}
+}
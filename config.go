@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/spf13/cobra"
+)
+
+// A bunvConfig holds user-wide defaults from the global config file.
+type bunvConfig struct {
+	// With lists packages added to every script, like --with.
+	With []string `toml:"with,omitempty"`
+	// Registry is the npm registry to install from, like --registry.
+	Registry string `toml:"registry,omitempty"`
+	// BunPath is the bun executable to use, like --bun-path.
+	BunPath string `toml:"bun-path,omitempty"`
+	// Types, when false, leaves out @types/node, like --no-types.
+	Types *bool `toml:"types,omitempty"`
+	// CacheDirMode is the permission mode for cache directories, like
+	// --cache-dir-mode.
+	CacheDirMode string `toml:"cache-dir-mode,omitempty"`
+	// OnMiss and OnHit are shell commands run on cache events, like
+	// --on-miss and --on-hit.
+	OnMiss string `toml:"on-miss,omitempty"`
+	OnHit  string `toml:"on-hit,omitempty"`
+}
+
+// configKeys are the settings 'bunv config' can get and set.
+var configKeys = []string{"bun-path", "cache-dir-mode", "on-hit", "on-miss", "registry", "types", "with"}
+
+// globalConfig is the loaded global config, set by applyConfig.
+var globalConfig bunvConfig
+
+// configPath returns $BUNV_CONFIG, else ~/.bunv/config.toml.
+func configPath() string {
+	if path := os.Getenv("BUNV_CONFIG"); path != "" {
+		return path
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(homeDir, ".bunv", "config.toml")
+}
+
+// loadConfig reads the global config file. A missing file is an empty config.
+func loadConfig() (bunvConfig, error) {
+	var cfg bunvConfig
+	path := configPath()
+	if path == "" {
+		return cfg, nil
+	}
+	meta, err := toml.DecodeFile(path, &cfg)
+	if os.IsNotExist(err) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, err
+	}
+	if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+		fmt.Fprintf(os.Stderr, "Warning: Unknown setting %s in %s\n", undecoded[0], path)
+	}
+	return cfg, nil
+}
+
+// saveConfig writes cfg to the global config file.
+func saveConfig(cfg bunvConfig) error {
+	path := configPath()
+	if path == "" {
+		return fmt.Errorf("could not determine the home directory")
+	}
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(cfg); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, buf.Bytes(), 0644)
+}
+
+// applyConfig applies the global config to cmd's flags that weren't given.
+func applyConfig(cmd *cobra.Command) {
+	cfg, err := loadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading config: %v\n", err)
+		os.Exit(1)
+	}
+	globalConfig = cfg
+	flags := cmd.Flags()
+	if cfg.CacheDirMode != "" && flags.Lookup("cache-dir-mode") != nil && !flags.Changed("cache-dir-mode") {
+		cacheDirMode = cfg.CacheDirMode
+	}
+	if cfg.OnMiss != "" && flags.Lookup("on-miss") != nil && !flags.Changed("on-miss") {
+		onMissHook = cfg.OnMiss
+	}
+	if cfg.OnHit != "" && flags.Lookup("on-hit") != nil && !flags.Changed("on-hit") {
+		onHitHook = cfg.OnHit
+	}
+}
+
+// configValue returns the value of key in cfg as 'bunv config get' prints it.
+func configValue(cfg bunvConfig, key string) string {
+	switch key {
+	case "with":
+		return strings.Join(cfg.With, ",")
+	case "registry":
+		return cfg.Registry
+	case "bun-path":
+		return cfg.BunPath
+	case "types":
+		if cfg.Types == nil {
+			return ""
+		}
+		return strconv.FormatBool(*cfg.Types)
+	case "cache-dir-mode":
+		return cfg.CacheDirMode
+	case "on-miss":
+		return cfg.OnMiss
+	case "on-hit":
+		return cfg.OnHit
+	}
+	return ""
+}
+
+// setConfigValue sets key in cfg from its command line form.
+func setConfigValue(cfg *bunvConfig, key, value string) error {
+	switch key {
+	case "with":
+		cfg.With = nil
+		for _, pkg := range strings.Split(value, ",") {
+			if pkg = strings.TrimSpace(pkg); pkg != "" {
+				cfg.With = append(cfg.With, pkg)
+			}
+		}
+	case "registry":
+		cfg.Registry = value
+	case "bun-path":
+		cfg.BunPath = value
+	case "types":
+		if value == "" {
+			cfg.Types = nil
+			return nil
+		}
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("types must be true or false")
+		}
+		cfg.Types = &b
+	case "cache-dir-mode":
+		if value != "" {
+			if _, _, err := parseCacheDirMode(value); err != nil {
+				return err
+			}
+		}
+		cfg.CacheDirMode = value
+	case "on-miss":
+		cfg.OnMiss = value
+	case "on-hit":
+		cfg.OnHit = value
+	default:
+		return fmt.Errorf("unknown setting %q (known: %s)", key, strings.Join(configKeys, ", "))
+	}
+	return nil
+}
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Show or change the defaults in the global config file",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := loadConfig()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading config: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("# %s\n", configPath())
+		keys := append([]string(nil), configKeys...)
+		sort.Strings(keys)
+		for _, key := range keys {
+			if value := configValue(cfg, key); value != "" {
+				fmt.Printf("%s = %s\n", key, value)
+			}
+		}
+	},
+}
+
+var configGetCmd = &cobra.Command{
+	Use:   "get <key>",
+	Short: "Print a setting from the global config file",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := loadConfig()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading config: %v\n", err)
+			os.Exit(1)
+		}
+		if !slices.Contains(configKeys, args[0]) {
+			fmt.Fprintf(os.Stderr, "Error: unknown setting %q (known: %s)\n", args[0], strings.Join(configKeys, ", "))
+			os.Exit(1)
+		}
+		fmt.Println(configValue(cfg, args[0]))
+	},
+}
+
+var configSetCmd = &cobra.Command{
+	Use:   "set <key> <value>",
+	Short: "Change a setting in the global config file (an empty value unsets it)",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := loadConfig()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading config: %v\n", err)
+			os.Exit(1)
+		}
+		if err := setConfigValue(&cfg, args[0], args[1]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if err := saveConfig(cfg); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing config: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	configCmd.AddCommand(configGetCmd)
+	configCmd.AddCommand(configSetCmd)
+	rootCmd.AddCommand(configCmd)
+}
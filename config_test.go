@@ -0,0 +1,118 @@
+package main
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// withConfigSettings restores the settings a test changes.
+func withConfigSettings(t *testing.T) {
+	t.Helper()
+	cfg, flagBun, reg, with, override, types := globalConfig, bunPathOverride, registry, withPackages, withOverride, noTypes
+	t.Cleanup(func() {
+		globalConfig, bunPathOverride, registry, withPackages, withOverride, noTypes = cfg, flagBun, reg, with, override, types
+	})
+}
+
+func TestConfigPrecedenceBunPath(t *testing.T) {
+	withConfigSettings(t)
+	dir := t.TempDir()
+	bun := map[string]string{}
+	for _, source := range []string{"flag", "env", "config"} {
+		bun[source] = filepath.Join(dir, "bun-"+source+".exe")
+		if err := os.WriteFile(bun[source], nil, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		flag, env, config, want string
+	}{
+		{bun["flag"], bun["env"], bun["config"], bun["flag"]},
+		{"", bun["env"], bun["config"], bun["env"]},
+		{"", "", bun["config"], bun["config"]},
+	}
+	for _, tt := range tests {
+		bunPathOverride = tt.flag
+		t.Setenv("BUNV_BUN", tt.env)
+		globalConfig = bunvConfig{BunPath: tt.config}
+		if got, err := ensureBun(); err != nil || got != tt.want {
+			t.Errorf("flag %q, env %q, config %q: ensureBun = %q, %v; want %q", tt.flag, tt.env, tt.config, got, err, tt.want)
+		}
+	}
+}
+
+func TestConfigPrecedenceRegistry(t *testing.T) {
+	withConfigSettings(t)
+	withHeader := writeScript(t, "// /// script\n// {\"registry\": \"https://header.example.com\"}\n// ///\n")
+	withoutHeader := writeScript(t, "console.log(1)\n")
+
+	tests := []struct {
+		flag, script, config, want string
+	}{
+		{"https://flag.example.com", withHeader, "https://config.example.com", "https://flag.example.com"},
+		{"", withHeader, "https://config.example.com", "https://header.example.com"},
+		{"", withoutHeader, "https://config.example.com", "https://config.example.com"},
+		{"", withoutHeader, "", ""},
+	}
+	for _, tt := range tests {
+		registry = tt.flag
+		globalConfig = bunvConfig{Registry: tt.config}
+		if got, err := scriptRegistry(tt.script); err != nil || got != tt.want {
+			t.Errorf("flag %q, header %v, config %q: scriptRegistry = %q, %v; want %q", tt.flag, tt.script == withHeader, tt.config, got, err, tt.want)
+		}
+	}
+}
+
+func TestConfigPrecedenceTypes(t *testing.T) {
+	withConfigSettings(t)
+	pinned := writeScript(t, "// /// script\n// {\"typesNode\": \"20\"}\n// ///\n")
+	plain := writeScript(t, "console.log(1)\n")
+	off, on := false, true
+
+	tests := []struct {
+		flag   bool
+		script string
+		config *bool
+		want   string
+	}{
+		{true, pinned, &on, ""},
+		{false, pinned, &off, "20"},
+		{false, plain, &off, ""},
+		{false, plain, &on, "latest"},
+		{false, plain, nil, "latest"},
+	}
+	for _, tt := range tests {
+		noTypes = tt.flag
+		globalConfig = bunvConfig{Types: tt.config}
+		if got := nodeTypesVersion(tt.script); got != tt.want {
+			t.Errorf("--no-types %v, header pin %v, config %v: nodeTypesVersion = %q, want %q", tt.flag, tt.script == pinned, tt.config, got, tt.want)
+		}
+	}
+}
+
+func TestConfigMergesWithPackages(t *testing.T) {
+	withConfigSettings(t)
+	script := writeScript(t, "// /// script\n// {\"dependencies\": {\"zod\": \"3.0.0\"}}\n// ///\n")
+	globalConfig = bunvConfig{With: []string{"zod@2.0.0", "chalk@4", "lodash"}}
+	noTypes = true
+
+	tests := []struct {
+		with     []string
+		override bool
+		want     Dependencies
+	}{
+		// The header beats --with, which beats the config
+		{nil, false, Dependencies{"zod": "3.0.0", "chalk": "4", "lodash": "latest"}},
+		{[]string{"zod@3.1.0", "chalk@5"}, false, Dependencies{"zod": "3.0.0", "chalk": "5", "lodash": "latest"}},
+		// --with-override puts --with above the header
+		{[]string{"zod@3.1.0", "chalk@5"}, true, Dependencies{"zod": "3.1.0", "chalk": "5", "lodash": "latest"}},
+	}
+	for _, tt := range tests {
+		withPackages, withOverride = tt.with, tt.override
+		if got := getDependencies(script, "bun"); !maps.Equal(got, tt.want) {
+			t.Errorf("--with %q (override %v): dependencies = %v, want %v", tt.with, tt.override, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// A doctorCheck is the outcome of one environment check.
+type doctorCheck struct {
+	Name     string
+	OK       bool
+	Critical bool
+	Detail   string
+}
+
+// checkBun checks that a bun executable can be found and reports its version.
+func checkBun() doctorCheck {
+	c := doctorCheck{Name: "bun", Critical: true}
+	bunPath, err := ensureBun()
+	if err != nil {
+		c.Detail = strings.TrimSpace(err.Error())
+		return c
+	}
+	version := getBunVersion(bunPath)
+	if version == "" {
+		c.Detail = fmt.Sprintf("%s does not report a version", bunPath)
+		return c
+	}
+	c.OK = true
+	c.Detail = fmt.Sprintf("%s (%s)", bunPath, version)
+	return c
+}
+
+// checkHome checks that the home directory can be determined.
+func checkHome() doctorCheck {
+	c := doctorCheck{Name: "home directory"}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		c.Detail = fmt.Sprintf("%v; the cache falls back to %s", err, filepath.Join(os.TempDir(), "bunv-cache"))
+		return c
+	}
+	c.OK = true
+	c.Detail = homeDir
+	return c
+}
+
+// checkCacheWritable checks that files can be created under the cache root.
+func checkCacheWritable(root string) doctorCheck {
+	c := doctorCheck{Name: "cache root", Critical: true}
+	if err := os.MkdirAll(root, cacheDirPerm); err != nil {
+		c.Detail = err.Error()
+		return c
+	}
+	f, err := os.CreateTemp(root, ".doctor-")
+	if err != nil {
+		c.Detail = fmt.Sprintf("%s is not writable: %v", root, err)
+		return c
+	}
+	f.Close()
+	os.Remove(f.Name())
+	c.OK = true
+	c.Detail = root + " is writable"
+	return c
+}
+
+// checkHardlink checks that a file in dir can be hardlinked into the cache root.
+func checkHardlink(dir, root string) doctorCheck {
+	c := doctorCheck{Name: "hardlinks", Critical: true}
+	src, err := os.CreateTemp(dir, ".bunv-doctor-")
+	if err != nil {
+		// Can't test from here; fall back to a link within the cache.
+		if src, err = os.CreateTemp(root, ".doctor-"); err != nil {
+			c.Detail = err.Error()
+			return c
+		}
+		dir = root
+	}
+	src.Close()
+	defer os.Remove(src.Name())
+
+	dst := filepath.Join(root, filepath.Base(src.Name())+".link")
+	if err := os.Link(src.Name(), dst); err != nil {
+		c.Detail = fmt.Sprintf("cannot hardlink from %s into %s (%v); put the cache on the same filesystem with BUNV_CACHE_DIR", dir, root, err)
+		return c
+	}
+	os.Remove(dst)
+	c.OK = true
+	c.Detail = fmt.Sprintf("%s can be linked into %s", dir, root)
+	return c
+}
+
+// checkNodePath warns about an existing NODE_PATH.
+func checkNodePath() doctorCheck {
+	c := doctorCheck{Name: "NODE_PATH", OK: true, Detail: "not set"}
+	if nodePath := os.Getenv("NODE_PATH"); nodePath != "" {
+		c.OK = false
+		c.Detail = fmt.Sprintf("set to %s; bunv searches its cache first, but scripts can still import packages from there without declaring them", nodePath)
+	}
+	return c
+}
+
+// checkConfig checks that the global config file can be read, and loads it.
+func checkConfig() doctorCheck {
+	c := doctorCheck{Name: "config", Critical: true}
+	cfg, err := loadConfig()
+	if err != nil {
+		c.Detail = fmt.Sprintf("%s: %v", configPath(), err)
+		return c
+	}
+	globalConfig = cfg
+	c.OK = true
+	c.Detail = configPath()
+	return c
+}
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Check that bun and the cache are set up correctly",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		root := getCacheRoot()
+		cwd, err := os.Getwd()
+		if err != nil {
+			cwd = root
+		}
+		checks := []doctorCheck{checkConfig(), checkBun(), checkHome(), checkCacheWritable(root)}
+		if checks[len(checks)-1].OK {
+			checks = append(checks, checkHardlink(cwd, root))
+		}
+		checks = append(checks, checkNodePath())
+
+		failed := false
+		for _, c := range checks {
+			marker := "ok"
+			if !c.OK && c.Critical {
+				marker = "FAIL"
+				failed = true
+			} else if !c.OK {
+				marker = "warn"
+			}
+			fmt.Printf("[%s] %s: %s\n", marker, c.Name, c.Detail)
+		}
+		if failed {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestParseEnvFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    [][2]string
+	}{
+		{"bare", "A=1\nB = two words \n", [][2]string{{"A", "1"}, {"B", "two words"}}},
+		{"comments", "# comment\n\nA=1 # trailing\nB=a#b\n", [][2]string{{"A", "1"}, {"B", "a#b"}}},
+		{"export", "export A=1\n", [][2]string{{"A", "1"}}},
+		{"double quotes", `A="line\nbreak \"quoted\"" # comment` + "\n", [][2]string{{"A", "line\nbreak \"quoted\""}}},
+		{"single quotes", `A='no\nescapes # here'` + "\n", [][2]string{{"A", `no\nescapes # here`}}},
+		{"empty", "A=\nB=''\n", [][2]string{{"A", ""}, {"B", ""}}},
+		{"repeated", "A=1\nA=2\n", [][2]string{{"A", "1"}, {"A", "2"}}},
+		{"malformed", "no equals sign\n1A=x\nA-B=x\nA=\"unterminated\nB='x' y\nC=ok\n", [][2]string{{"C", "ok"}}},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), ".env")
+		if err := os.WriteFile(path, []byte(tt.content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := parseEnvFile(path)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: parseEnvFile = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	if _, err := parseEnvFile(filepath.Join(t.TempDir(), "missing.env")); err == nil {
+		t.Error("parseEnvFile of a missing file succeeded")
+	}
+}
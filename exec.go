@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// writeInlineScript writes code to a temporary script in the cache root.
+func writeInlineScript(code string) (string, error) {
+	root := getCacheRoot()
+	if err := os.MkdirAll(root, cacheDirPerm); err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp(root, ".exec-*.ts")
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.WriteString(code + "\n"); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), f.Close()
+}
+
+// isInlineScript reports whether path is a snippet written by writeInlineScript.
+func isInlineScript(path string) bool {
+	return filepath.Dir(path) == filepath.Clean(getCacheRoot()) && strings.HasPrefix(filepath.Base(path), ".exec-")
+}
+
+var execCmd = &cobra.Command{
+	Use:   "exec [--with dep]... <code> [-- script-args...]",
+	Short: "Run a snippet of TypeScript given on the command line",
+	Run: func(cmd *cobra.Command, args []string) {
+		code, _ := cmd.Flags().GetString("code")
+		scriptArgs := args
+		if code == "" {
+			if len(args) == 0 || cmd.ArgsLenAtDash() == 0 {
+				fmt.Fprintf(os.Stderr, "Error: No code given (pass it as an argument or with --code)\n")
+				os.Exit(1)
+			}
+			code, scriptArgs = args[0], args[1:]
+		}
+
+		scriptFile, err := writeInlineScript(code)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing script: %v\n", err)
+			os.Exit(1)
+		}
+
+		// bunv run does the rest, so the cache, --with and the exit status
+		// behave exactly as for a script file.
+		runArgs := []string{"run"}
+		for _, pkg := range withPackages {
+			runArgs = append(runArgs, "--with", pkg)
+		}
+		if verbose {
+			runArgs = append(runArgs, "--verbose")
+		}
+		if quiet {
+			runArgs = append(runArgs, "--quiet")
+		}
+		runArgs = append(append(runArgs, "--", scriptFile), scriptArgs...)
+
+		self, err := os.Executable()
+		if err != nil {
+			os.Remove(scriptFile)
+			fmt.Fprintf(os.Stderr, "Error finding bunv executable: %v\n", err)
+			os.Exit(1)
+		}
+		runCmd := exec.Command(self, runArgs...)
+		runCmd.Stdin = os.Stdin
+		runCmd.Stdout = os.Stdout
+		runCmd.Stderr = os.Stderr
+		err = runCmd.Run()
+		os.Remove(scriptFile)
+		if _, ok := err.(*exec.ExitError); err != nil && !ok {
+			fmt.Fprintf(os.Stderr, "Error executing bunv: %v\n", err)
+			os.Exit(1)
+		}
+		exitCode, _ := exitStatus(runCmd.ProcessState)
+		os.Exit(exitCode)
+	},
+}
+
+func init() {
+	execCmd.Flags().StringSliceVar(&withPackages, "with", []string{}, "Packages to install temporarily, as for 'bunv run --with'")
+	execCmd.Flags().String("code", "", "Code to run, instead of the first argument")
+	rootCmd.AddCommand(execCmd)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var networkTimeout = 30 * time.Second
+
+// errNetworkTimeout is returned when a request exceeds --network-timeout.
+var errNetworkTimeout = errors.New("network timeout")
+
+// fetchURL performs a GET request bounded by --network-timeout and returns the body.
+func fetchURL(url string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), networkTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("%w: fetching %s took longer than %s", errNetworkTimeout, url, networkTimeout)
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", url, resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return nil, fmt.Errorf("%w: fetching %s took longer than %s", errNetworkTimeout, url, networkTimeout)
+	}
+	return body, err
+}
+
+// registryURL is the npm registry queried for package versions.
+var registryURL = "https://registry.npmjs.org"
+
+// latestVersion returns the registry's "latest" version of the named package.
+func latestVersion(name string) (string, error) {
+	// Scoped names keep their '@' but the '/' must be escaped.
+	body, err := fetchURL(strings.TrimSuffix(registryURL, "/") + "/" + strings.Replace(name, "/", "%2F", 1) + "/latest")
+	if err != nil {
+		return "", err
+	}
+	var manifest struct {
+		Version string `json:"version"`
+	}
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return "", fmt.Errorf("parsing registry response for %s: %v", name, err)
+	}
+	if manifest.Version == "" {
+		return "", fmt.Errorf("registry has no latest version for %s", name)
+	}
+	return manifest.Version, nil
+}
+
+// isRemoteScript reports whether arg is an http or https URL.
+func isRemoteScript(arg string) bool {
+	u, err := url.Parse(arg)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// fetchRemoteScript downloads the script at rawURL into root and returns its path.
+func fetchRemoteScript(rawURL, root string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	name := path.Base(u.Path)
+	if name == "/" || name == "." {
+		name = "script.ts"
+	}
+	dir := filepath.Join(root, ".remote", fmt.Sprintf("%x", sha256.Sum256([]byte(rawURL)))[:16])
+	scriptPath := filepath.Join(dir, name)
+
+	body, err := fetchURL(rawURL)
+	if err == nil && (!utf8.Valid(body) || bytes.IndexByte(body, 0) >= 0) {
+		return "", fmt.Errorf("%s does not look like a text file", rawURL)
+	}
+	if err != nil {
+		if _, statErr := os.Stat(scriptPath); statErr == nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v; using the copy fetched earlier\n", err)
+			return scriptPath, nil
+		}
+		return "", err
+	}
+
+	if err := os.MkdirAll(dir, cacheDirPerm); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(scriptPath, body, cacheFilePerm); err != nil {
+		return "", err
+	}
+	return scriptPath, nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// slowServer returns a server that answers each request after delay, or when the client gives up.
+func slowServer(t *testing.T, delay time.Duration) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(delay):
+			w.Write([]byte(`{"version": "1.0.0"}`))
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestNetworkTimeout(t *testing.T) {
+	defer func(d time.Duration) { networkTimeout = d }(networkTimeout)
+	networkTimeout = 50 * time.Millisecond
+	srv := slowServer(t, 5*time.Second)
+
+	start := time.Now()
+	if _, err := fetchRemoteScript(srv.URL+"/script.ts", t.TempDir()); !errors.Is(err, errNetworkTimeout) {
+		t.Errorf("fetchRemoteScript error = %v, want errNetworkTimeout", err)
+	}
+	defer func(u string) { registryURL = u }(registryURL)
+	registryURL = srv.URL
+	if _, err := latestVersion("zod"); !errors.Is(err, errNetworkTimeout) {
+		t.Errorf("latestVersion error = %v, want errNetworkTimeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("requests took %s, want them cut off by --network-timeout", elapsed)
+	}
+
+	// A server that answers within the timeout is fine
+	networkTimeout = 5 * time.Second
+	registryURL = slowServer(t, 10*time.Millisecond).URL
+	if version, err := latestVersion("zod"); err != nil || version != "1.0.0" {
+		t.Errorf("latestVersion = %q, %v; want 1.0.0", version, err)
+	}
+}
+
+const remoteScript = "// /// script\n// {\"dependencies\": {\"left-pad\": \"1.3.0\"}}\n// ///\nconsole.log(1)\n"
+
+func TestFetchRemoteScript(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/script.ts":
+			w.Write([]byte(remoteScript))
+		case "/binary.ts":
+			w.Write([]byte{0x7f, 'E', 'L', 'F', 0})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	root := t.TempDir()
+
+	path, err := fetchRemoteScript(srv.URL+"/script.ts", root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Base(path) != "script.ts" || !strings.HasPrefix(path, filepath.Join(root, ".remote")+string(filepath.Separator)) {
+		t.Errorf("script fetched to %s, want script.ts under %s/.remote", path, root)
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != remoteScript {
+		t.Errorf("fetched script = %q, %v", data, err)
+	}
+	if deps, err := extractDependenciesFromHeader(path); err != nil || deps["left-pad"] != "1.3.0" {
+		t.Errorf("dependencies of the fetched script = %v, %v", deps, err)
+	}
+
+	if _, err := fetchRemoteScript(srv.URL+"/missing.ts", root); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("fetching a missing script: error = %v, want a 404", err)
+	}
+	if _, err := fetchRemoteScript(srv.URL+"/binary.ts", root); err == nil || !strings.Contains(err.Error(), "does not look like a text file") {
+		t.Errorf("fetching a binary: error = %v, want it rejected", err)
+	}
+}
+
+func TestFetchRemoteScriptFallsBackToEarlierCopy(t *testing.T) {
+	up := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !up {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(remoteScript))
+	}))
+	defer srv.Close()
+	root := t.TempDir()
+
+	first, err := fetchRemoteScript(srv.URL+"/s.ts", root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	up = false
+	second, err := fetchRemoteScript(srv.URL+"/s.ts", root)
+	if err != nil || second != first {
+		t.Errorf("fetch while the server is down = %q, %v; want the earlier copy %q", second, err, first)
+	}
+	if _, err := fetchRemoteScript(srv.URL+"/other.ts", root); err == nil {
+		t.Error("fetch of a never-fetched script succeeded while the server is down")
+	}
+}
@@ -2,7 +2,13 @@ module bunv
 
 go 1.24.2
 
-require github.com/spf13/cobra v1.9.1
+require (
+	github.com/BurntSushi/toml v1.4.0
+	github.com/fsnotify/fsnotify v1.8.0
+	github.com/spf13/cobra v1.9.1
+	golang.org/x/sys v0.31.0
+	golang.org/x/term v0.30.0
+)
 
 require (
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
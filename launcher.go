@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// envNameRe matches variable names that are safe to write into a launcher.
+var envNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// writeLauncher writes a standalone script that runs bun on the script from cacheDir.
+func writeLauncher(path, bunPath, cacheDir string, scriptEnv [][2]string, bunArgs []string) error {
+	for _, kv := range scriptEnv {
+		if !envNameRe.MatchString(kv[0]) {
+			return fmt.Errorf("invalid environment variable name %q", kv[0])
+		}
+	}
+	var b strings.Builder
+	if strings.HasSuffix(strings.ToLower(path), ".cmd") {
+		b.WriteString("@echo off\r\n")
+		b.WriteString("rem Generated by bunv\r\n")
+		dir, err := cmdEscape(cacheDir)
+		if err != nil {
+			return fmt.Errorf("cache directory: %w", err)
+		}
+		fmt.Fprintf(&b, "set \"NODE_PATH=%s;%%NODE_PATH%%\"\r\n", dir)
+		for _, kv := range scriptEnv {
+			value, err := cmdEscape(kv[1])
+			if err != nil {
+				return fmt.Errorf("environment variable %s: %w", kv[0], err)
+			}
+			fmt.Fprintf(&b, "set \"%s=%s\"\r\n", kv[0], value)
+		}
+		argv := append([]string{bunPath}, bunArgs...)
+		quoted := make([]string, len(argv))
+		for i, a := range argv {
+			if strings.ContainsAny(a, "\r\n") {
+				return fmt.Errorf("argument %q contains a line break", a)
+			}
+			quoted[i] = `"` + strings.ReplaceAll(strings.ReplaceAll(a, "%", "%%"), `"`, `""`) + `"`
+		}
+		fmt.Fprintf(&b, "%s %%*\r\n", strings.Join(quoted, " "))
+	} else {
+		b.WriteString("#!/bin/sh\n")
+		b.WriteString("# Generated by bunv\n")
+		fmt.Fprintf(&b, "NODE_PATH=%s${NODE_PATH:+:$NODE_PATH}\n", shellQuote([]string{cacheDir}))
+		b.WriteString("export NODE_PATH\n")
+		for _, kv := range scriptEnv {
+			fmt.Fprintf(&b, "export %s=%s\n", kv[0], shellQuote([]string{kv[1]}))
+		}
+		fmt.Fprintf(&b, "exec %s \"$@\"\n", shellQuote(append([]string{bunPath}, bunArgs...)))
+	}
+	return os.WriteFile(path, []byte(b.String()), 0755)
+}
+
+// cmdEscape escapes s for use inside a quoted set "name=value" in a batch file.
+func cmdEscape(s string) (string, error) {
+	// A quote would end the quoted assignment early and a line break would
+	// end the command, and neither can be escaped there
+	if strings.ContainsAny(s, "\"\r\n") {
+		return "", fmt.Errorf("value %q contains a quote or line break, which a .cmd launcher can't represent", s)
+	}
+	return strings.ReplaceAll(s, "%", "%%"), nil
+}
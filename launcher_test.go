@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteLauncherUsesBunPath(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name, want string
+	}{
+		{"launch.sh", "exec '/opt/bun 1.1/bin/bun' run script.ts \"$@\"\n"},
+		{"launch.cmd", "\"/opt/bun 1.1/bin/bun\" \"run\" \"script.ts\" %*\r\n"},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(dir, tt.name)
+		if err := writeLauncher(path, "/opt/bun 1.1/bin/bun", "/cache/abc", nil, []string{"run", "script.ts"}); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasSuffix(string(data), tt.want) {
+			t.Errorf("%s:\n%s\nwant it to end with %q", tt.name, data, tt.want)
+		}
+	}
+}
+
+func TestWriteLauncherEscapesEnv(t *testing.T) {
+	dir := t.TempDir()
+	env := [][2]string{{"GREETING", "100% $HOME 'x' & y"}}
+	tests := []struct {
+		name, want string
+	}{
+		{"launch.sh", "export GREETING='100% $HOME '\\''x'\\'' & y'\n"},
+		{"launch.cmd", "set \"GREETING=100%% $HOME 'x' & y\"\r\n"},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(dir, tt.name)
+		if err := writeLauncher(path, "bun", "/cache/abc", env, []string{"run", "50%.ts"}); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(data), tt.want) {
+			t.Errorf("%s:\n%s\nwant it to contain %q", tt.name, data, tt.want)
+		}
+	}
+	data, _ := os.ReadFile(filepath.Join(dir, "launch.cmd"))
+	if !strings.Contains(string(data), `"50%%.ts"`) {
+		t.Errorf("launch.cmd doesn't escape %% in arguments:\n%s", data)
+	}
+}
+
+func TestWriteLauncherRejectsUnsafeEnv(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name string
+		env  [][2]string
+	}{
+		{"launch.sh", [][2]string{{"X; rm -rf ~", "1"}}},
+		{"launch.cmd", [][2]string{{"X\" & calc & \"", "1"}}},
+		{"launch.sh", [][2]string{{"1X", "1"}}},
+		{"launch.cmd", [][2]string{{"X", `a" & calc & "`}}},
+		{"launch.cmd", [][2]string{{"X", "a\r\ncalc"}}},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(dir, tt.name)
+		if err := writeLauncher(path, "bun", "/cache/abc", tt.env, []string{"run", "script.ts"}); err == nil {
+			t.Errorf("writeLauncher(%s, %q) succeeded", tt.name, tt.env)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/spf13/cobra"
+)
+
+// parseByteSize parses a size such as "512M" or "2G", or a number of bytes.
+func parseByteSize(size string) (uint64, error) {
+	s := strings.TrimSpace(strings.ToUpper(size))
+	multiplier := uint64(1)
+	for suffix, m := range map[string]uint64{"K": 1 << 10, "M": 1 << 20, "G": 1 << 30} {
+		if strings.HasSuffix(s, suffix) || strings.HasSuffix(s, suffix+"B") {
+			s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), suffix)
+			multiplier = m
+			break
+		}
+	}
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil || n == 0 {
+		return 0, fmt.Errorf("invalid size %q", size)
+	}
+	return n * multiplier, nil
+}
+
+// limitedCommand returns the command that runs path with args under the given limits.
+func limitedCommand(path string, args []string, memoryBytes, cpuSeconds uint64) (string, []string, error) {
+	self, err := os.Executable()
+	if err != nil {
+		return "", nil, err
+	}
+	shimArgs := []string{
+		limitExecCmd.Name(),
+		"--memory", strconv.FormatUint(memoryBytes, 10),
+		"--cpu", strconv.FormatUint(cpuSeconds, 10),
+		"--", path,
+	}
+	return self, append(shimArgs, args...), nil
+}
+
+var limitExecCmd = &cobra.Command{
+	Use:    "limit-exec --memory <bytes> --cpu <seconds> -- <command> [args...]",
+	Short:  "Run a command under resource limits (used internally by run)",
+	Hidden: true,
+	Args:   cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		memoryBytes, _ := cmd.Flags().GetUint64("memory")
+		cpuSeconds, _ := cmd.Flags().GetUint64("cpu")
+		if err := applyResourceLimits(memoryBytes, cpuSeconds); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Resource limits not applied: %v\n", err)
+		}
+		err := syscall.Exec(args[0], args, os.Environ())
+		fmt.Fprintf(os.Stderr, "Error executing %s: %v\n", args[0], err)
+		os.Exit(1)
+	},
+}
+
+func init() {
+	limitExecCmd.Flags().Uint64("memory", 0, "Data memory limit in bytes (0 for none)")
+	limitExecCmd.Flags().Uint64("cpu", 0, "CPU time limit in seconds (0 for none)")
+	rootCmd.AddCommand(limitExecCmd)
+}
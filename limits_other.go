@@ -0,0 +1,12 @@
+//go:build !linux && !darwin
+
+package main
+
+import "errors"
+
+// resourceLimitsSupported reports whether --limit-memory and --limit-cpu work here.
+const resourceLimitsSupported = false
+
+func applyResourceLimits(memoryBytes uint64, cpuSeconds uint64) error {
+	return errors.New("resource limits are not supported on this platform")
+}
@@ -0,0 +1,28 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// resourceLimitsSupported reports whether --limit-memory and --limit-cpu work here.
+const resourceLimitsSupported = true
+
+// applyResourceLimits limits the memory and CPU time of this process, for bun to inherit.
+func applyResourceLimits(memoryBytes uint64, cpuSeconds uint64) error {
+	if memoryBytes > 0 {
+		lim := &syscall.Rlimit{Cur: memoryBytes, Max: memoryBytes}
+		if err := syscall.Setrlimit(syscall.RLIMIT_DATA, lim); err != nil {
+			return fmt.Errorf("setting memory limit: %v", err)
+		}
+	}
+	if cpuSeconds > 0 {
+		lim := &syscall.Rlimit{Cur: cpuSeconds, Max: cpuSeconds}
+		if err := syscall.Setrlimit(syscall.RLIMIT_CPU, lim); err != nil {
+			return fmt.Errorf("setting CPU limit: %v", err)
+		}
+	}
+	return nil
+}
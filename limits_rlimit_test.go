@@ -0,0 +1,37 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestLimitedCommand(t *testing.T) {
+	var before syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_CPU, &before); err != nil {
+		t.Fatal(err)
+	}
+
+	path, args, err := limitedCommand("/bin/sh", []string{"-c", "ulimit -t; ulimit -d"}, 64<<20, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := exec.Command(path, args...).Output()
+	if err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+	if got := strings.Fields(string(out)); len(got) != 2 || got[0] != "7" || got[1] != "65536" {
+		t.Errorf("child limits are %q, want CPU 7s and data 65536K", got)
+	}
+
+	var after syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_CPU, &after); err != nil {
+		t.Fatal(err)
+	}
+	if after != before {
+		t.Errorf("CPU limit of this process changed from %+v to %+v", before, after)
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		size string
+		want uint64
+	}{
+		{"1024", 1024},
+		{"512K", 512 << 10},
+		{"512m", 512 << 20},
+		{"2G", 2 << 30},
+		{"2GB", 2 << 30},
+	}
+	for _, tt := range tests {
+		if got, err := parseByteSize(tt.size); err != nil || got != tt.want {
+			t.Errorf("parseByteSize(%q) = %d, %v; want %d", tt.size, got, err, tt.want)
+		}
+	}
+	for _, size := range []string{"", "0", "-1", "12T", "lots"} {
+		if _, err := parseByteSize(size); err == nil {
+			t.Errorf("parseByteSize(%q) accepted an invalid size", size)
+		}
+	}
+}
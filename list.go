@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/spf13/cobra"
+)
+
+var listCmd = &cobra.Command{
+	Use:   "list <script.ts>",
+	Short: "Print the dependencies bunv resolves for a script",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		applyConfig(cmd)
+		scriptFile := args[0]
+		if _, err := os.Stat(scriptFile); os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "Error: File %s does not exist\n", scriptFile)
+			os.Exit(1)
+		}
+		deps := getDependencies(scriptFile, defaultEngine)
+
+		if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+			data, err := json.MarshalIndent(deps, "", "  ")
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error serializing dependencies: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Println(string(data))
+			return
+		}
+
+		names := make([]string, 0, len(deps))
+		for name := range deps {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Printf("%s@%s\n", name, deps[name])
+		}
+		installRegistry, err := scriptRegistry(scriptFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		aliases, err := scriptAliases(scriptFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("cache hash: %s\n", deps.CacheKey(installedBunVersion(), installRegistry, aliases))
+	},
+}
+
+func init() {
+	listCmd.Flags().StringSliceVar(&withPackages, "with", []string{}, "Packages to include as 'bunv run --with' would")
+	listCmd.Flags().BoolVar(&withOverride, "with-override", false, "Let --with versions take precedence over the header, as for 'bunv run'")
+	listCmd.Flags().BoolVar(&noTypes, "no-types", false, "Leave out @types/node as 'bunv run --no-types' would")
+	listCmd.Flags().StringVar(&typesNodeVersion, "types-node-version", "", "Version of @types/node, as for 'bunv run'")
+	listCmd.Flags().StringVar(&registry, "registry", "", "Registry URL, as for 'bunv run'")
+	listCmd.Flags().Bool("json", false, "Print the dependencies as a JSON object")
+	rootCmd.AddCommand(listCmd)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// installLock is the content of the lock file held while a cache entry is being installed.
+type installLock struct {
+	PID        int       `json:"pid"`
+	AcquiredAt time.Time `json:"acquiredAt"`
+}
+
+// lockPath returns the path of the install lock for cacheDir.
+func lockPath(cacheDir string) string {
+	return cacheDir + ".lock"
+}
+
+func readInstallLock(path string) (*installLock, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var lock installLock
+	if err := json.Unmarshal(data, &lock); err != nil {
+		return nil, err
+	}
+	return &lock, nil
+}
+
+// errLockHeld is returned by lockFile when another process holds the lock.
+var errLockHeld = errors.New("lock is held by another process")
+
+// acquireInstallLock blocks until this process holds the install lock for cacheDir.
+func acquireInstallLock(cacheDir string) (func(), error) {
+	return lockInstall(cacheDir, true)
+}
+
+// tryInstallLock takes the install lock for cacheDir, or returns errLockHeld if another process holds it.
+func tryInstallLock(cacheDir string) (func(), error) {
+	return lockInstall(cacheDir, false)
+}
+
+// lockInstall takes the install lock for cacheDir, waiting for it if wait is set.
+func lockInstall(cacheDir string, wait bool) (func(), error) {
+	path := lockPath(cacheDir)
+	if err := os.MkdirAll(filepath.Dir(path), cacheDirPerm); err != nil {
+		return nil, err
+	}
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, cacheFilePerm)
+		if err != nil {
+			return nil, err
+		}
+		err = lockFile(f, false)
+		if err == errLockHeld && wait {
+			if holder, err := readInstallLock(path); err == nil {
+				logf("Waiting for another bunv process (pid %d) to finish installing...\n", holder.PID)
+			} else {
+				logf("Waiting for another bunv process to finish installing...\n")
+			}
+			err = lockFile(f, true)
+		}
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+
+		// The previous holder removes the file on release, so the lock we
+		// got may be on a file that is no longer at path.
+		locked, err1 := f.Stat()
+		current, err2 := os.Stat(path)
+		if err1 != nil || err2 != nil || !os.SameFile(locked, current) {
+			f.Close()
+			continue
+		}
+
+		data, _ := json.Marshal(installLock{PID: os.Getpid(), AcquiredAt: time.Now()})
+		f.Truncate(0)
+		f.WriteAt(data, 0)
+		released := false
+		return func() {
+			if !released {
+				released = true
+				releaseLockFile(f, path, true)
+			}
+		}, nil
+	}
+}
+
+// probeInstallLock tries to take the lock file at path without waiting.
+func probeInstallLock(path string) (release func(remove bool) error, held bool, err error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := lockFile(f, false); err != nil {
+		f.Close()
+		if err == errLockHeld {
+			return nil, true, nil
+		}
+		return nil, false, err
+	}
+	return func(remove bool) error { return releaseLockFile(f, path, remove) }, false, nil
+}
+
+// listInstallLocks returns the lock files under the cache root, keyed by hash.
+func listInstallLocks(root string) (map[string]string, error) {
+	matches, err := filepath.Glob(filepath.Join(root, "*.lock"))
+	if err != nil {
+		return nil, err
+	}
+	locks := map[string]string{}
+	for _, m := range matches {
+		locks[strings.TrimSuffix(filepath.Base(m), ".lock")] = m
+	}
+	return locks, nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestProbeInstallLock(t *testing.T) {
+	cacheDir := filepath.Join(t.TempDir(), "abc")
+	release, err := acquireInstallLock(cacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, held, err := probeInstallLock(lockPath(cacheDir)); err != nil || !held {
+		t.Errorf("probe of a held lock = held %v, err %v; want held", held, err)
+	}
+	release()
+	if _, err := os.Stat(lockPath(cacheDir)); !os.IsNotExist(err) {
+		t.Errorf("lock file left after release: %v", err)
+	}
+
+	// A lock left behind by a dead process, or empty because its holder died
+	// before writing it, is not held whatever PID it names
+	for _, content := range []string{`{"pid": 1}`, ""} {
+		if err := os.WriteFile(lockPath(cacheDir), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		probeRelease, held, err := probeInstallLock(lockPath(cacheDir))
+		if err != nil || held {
+			t.Errorf("probe of abandoned lock %q = held %v, err %v; want not held", content, held, err)
+			continue
+		}
+		probeRelease(false)
+	}
+}
+
+func TestInstallLockContention(t *testing.T) {
+	cacheDir := filepath.Join(t.TempDir(), "abc")
+	var holders, installs atomic.Int32
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release, err := acquireInstallLock(cacheDir)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer release()
+			if n := holders.Add(1); n > 1 {
+				t.Errorf("%d goroutines hold the lock at once", n)
+			}
+			defer holders.Add(-1)
+			// Like bunv run: install only if the entry isn't there yet
+			if _, err := os.Stat(cacheDir); os.IsNotExist(err) {
+				installs.Add(1)
+				time.Sleep(20 * time.Millisecond)
+				os.Mkdir(cacheDir, 0o755)
+			}
+		}()
+	}
+	wg.Wait()
+	if installs.Load() != 1 {
+		t.Errorf("installed %d times, want once", installs.Load())
+	}
+}
+
+func TestTryInstallLock(t *testing.T) {
+	cacheDir := filepath.Join(t.TempDir(), "abc")
+	release, err := acquireInstallLock(cacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tryInstallLock(cacheDir); err != errLockHeld {
+		t.Errorf("tryInstallLock of a held lock = %v, want errLockHeld", err)
+	}
+	release()
+	release, err = tryInstallLock(cacheDir)
+	if err != nil {
+		t.Fatalf("tryInstallLock of a free lock = %v", err)
+	}
+	release()
+}
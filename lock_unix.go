@@ -0,0 +1,31 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile takes an exclusive flock on f, failing with errLockHeld unless wait is set.
+func lockFile(f *os.File, wait bool) error {
+	how := syscall.LOCK_EX
+	if !wait {
+		how |= syscall.LOCK_NB
+	}
+	err := syscall.Flock(int(f.Fd()), how)
+	if err == syscall.EWOULDBLOCK {
+		return errLockHeld
+	}
+	return err
+}
+
+// releaseLockFile unlocks f, removing path first when remove is set.
+func releaseLockFile(f *os.File, path string, remove bool) error {
+	var err error
+	if remove {
+		err = os.Remove(path)
+	}
+	f.Close()
+	return err
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// Verbosity of bunv's own messages, set by --verbose and --quiet.
+var (
+	verbose bool
+	quiet   bool
+)
+
+// logf prints a progress message unless --quiet is set.
+func logf(format string, args ...any) {
+	if !quiet {
+		fmt.Fprintf(os.Stderr, format, args...)
+	}
+}
+
+// debugf prints a detail when --verbose is set.
+func debugf(format string, args ...any) {
+	if verbose {
+		fmt.Fprintf(os.Stderr, "bunv: "+format, args...)
+	}
+}
+
+func init() {
+	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "Explain cache decisions and show command lines and timings")
+	rootCmd.PersistentFlags().BoolVarP(&quiet, "quiet", "q", false, "Only show errors and warnings, not progress or install output")
+	rootCmd.MarkFlagsMutuallyExclusive("verbose", "quiet")
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+// outdatedDependency is a dependency whose latest release is outside its declared range.
+type outdatedDependency struct {
+	Name    string `json:"name"`
+	Current string `json:"current"`
+	Latest  string `json:"latest"`
+}
+
+// lookupLatest resolves a package's latest version; tests replace it with a fake registry.
+var lookupLatest = latestVersion
+
+// findOutdated checks each of deps against the registry.
+func findOutdated(deps map[string]string) []outdatedDependency {
+	names := make([]string, 0, len(deps))
+	for name := range deps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var outdated []outdatedDependency
+	for _, name := range names {
+		spec := deps[name]
+		r, err := parseRange(spec)
+		if err != nil {
+			continue
+		}
+		latest, err := lookupLatest(name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Could not check %s: %v\n", name, err)
+			continue
+		}
+		if v, err := parseVersion(latest); err == nil && !r.Matches(v) {
+			outdated = append(outdated, outdatedDependency{name, spec, latest})
+		}
+	}
+	return outdated
+}
+
+// useScriptRegistry points registry lookups at the script's own registry, if it declares one.
+func useScriptRegistry(scriptFile string) {
+	reg, err := scriptRegistry(scriptFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if reg != "" {
+		registryURL = strings.TrimRight(reg, "/")
+	}
+}
+
+var outdatedCmd = &cobra.Command{
+	Use:   "outdated --script <script.ts>",
+	Short: "List dependencies with newer releases than the script allows",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		applyConfig(cmd)
+		scriptFile, _ := cmd.Flags().GetString("script")
+		if _, err := os.Stat(scriptFile); os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "Error: File %s does not exist\n", scriptFile)
+			os.Exit(1)
+		}
+		useScriptRegistry(scriptFile)
+		deps, _ := extractDependenciesFromHeader(scriptFile)
+		outdated := findOutdated(deps)
+
+		if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+			if outdated == nil {
+				outdated = []outdatedDependency{}
+			}
+			data, err := json.MarshalIndent(outdated, "", "  ")
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error serializing report: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Println(string(data))
+			return
+		}
+
+		if len(outdated) == 0 {
+			fmt.Println("All dependencies are up to date")
+			return
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "Package\tCurrent\tLatest")
+		for _, d := range outdated {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", d.Name, d.Current, d.Latest)
+		}
+		w.Flush()
+	},
+}
+
+var upgradeCmd = &cobra.Command{
+	Use:   "upgrade --script <script.ts> [dep...]",
+	Short: "Bump a script's dependencies to their latest releases",
+	Run: func(cmd *cobra.Command, args []string) {
+		applyConfig(cmd)
+		scriptFile, _ := cmd.Flags().GetString("script")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		install, _ := cmd.Flags().GetBool("install")
+
+		origBytes, err := os.ReadFile(scriptFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading script file: %v\n", err)
+			os.Exit(1)
+		}
+		useScriptRegistry(scriptFile)
+		header, before, after, _ := parseMetadataBlock(string(origBytes))
+		deps, _ := header["dependencies"].(map[string]any)
+
+		names := args
+		if len(names) == 0 {
+			for name := range deps {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+		}
+		changed := 0
+		for _, name := range names {
+			spec, ok := deps[name].(string)
+			if !ok {
+				fmt.Fprintf(os.Stderr, "Warning: %s is not in dependencies\n", name)
+				continue
+			}
+			if _, err := parseRange(spec); err != nil {
+				continue
+			}
+			latest, err := lookupLatest(name)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: Could not check %s: %v\n", name, err)
+				continue
+			}
+			// Keep a caret or tilde so the kind of range is preserved.
+			newSpec := latest
+			if strings.HasPrefix(spec, "^") || strings.HasPrefix(spec, "~") {
+				newSpec = spec[:1] + latest
+			}
+			if newSpec == spec {
+				continue
+			}
+			fmt.Printf("%s: %s -> %s\n", name, spec, newSpec)
+			deps[name] = newSpec
+			changed++
+		}
+		if changed == 0 {
+			fmt.Println("All dependencies are up to date")
+			return
+		}
+		if dryRun {
+			return
+		}
+
+		newBlock, err := renderMetadataBlock(header, string(origBytes))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error serializing metadata: %v\n", err)
+			os.Exit(1)
+		}
+		if err := os.WriteFile(scriptFile, []byte(before+newBlock+after), 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing updated script: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Updated dependencies in %s\n", scriptFile)
+
+		if install {
+			// --print-tree-hash installs into the cache and exits without
+			// running the script.
+			self, err := os.Executable()
+			if err == nil {
+				installCmd := internalRunCommand(self, "run", "--print-tree-hash", "--", scriptFile)
+				installCmd.Stderr = os.Stderr
+				err = installCmd.Run()
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error installing upgraded dependencies: %v\n", err)
+				os.Exit(1)
+			}
+		}
+	},
+}
+
+func init() {
+	outdatedCmd.Flags().String("script", "", "Script file to check")
+	outdatedCmd.MarkFlagRequired("script")
+	outdatedCmd.Flags().Bool("json", false, "Print the outdated dependencies as JSON")
+	rootCmd.AddCommand(outdatedCmd)
+	upgradeCmd.Flags().String("script", "", "Script file to update")
+	upgradeCmd.MarkFlagRequired("script")
+	upgradeCmd.Flags().Bool("dry-run", false, "Show the new versions without changing the script")
+	upgradeCmd.Flags().Bool("install", false, "Install the upgraded dependencies into the cache afterwards")
+	rootCmd.AddCommand(upgradeCmd)
+}
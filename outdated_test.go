@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// fakeRegistry maps package names to their latest versions in place of the npm registry.
+type fakeRegistry map[string]string
+
+func (r fakeRegistry) latest(name string) (string, error) {
+	if version, ok := r[name]; ok {
+		return version, nil
+	}
+	return "", fmt.Errorf("fetching %s: 404 Not Found", name)
+}
+
+// useFakeRegistry makes version lookups use r for the rest of the test.
+func useFakeRegistry(t *testing.T, r fakeRegistry) {
+	t.Helper()
+	orig := lookupLatest
+	t.Cleanup(func() { lookupLatest = orig })
+	lookupLatest = r.latest
+}
+
+func TestFindOutdated(t *testing.T) {
+	useFakeRegistry(t, fakeRegistry{
+		"zod":        "3.23.8",
+		"chalk":      "5.3.0",
+		"@types/bun": "1.1.0",
+		"left-pad":   "1.3.0",
+	})
+	got := findOutdated(map[string]string{
+		"zod":        "^3.20.0",
+		"chalk":      "4.1.2",
+		"@types/bun": "~1.0.0",
+		"left-pad":   "1.3.0",
+		"lodash":     "latest",
+		"missing":    "1.0.0",
+	})
+	want := []outdatedDependency{
+		{"@types/bun", "~1.0.0", "1.1.0"},
+		{"chalk", "4.1.2", "5.3.0"},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("findOutdated = %v, want %v", got, want)
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+	t.Setenv("BUNV_CONFIG", filepath.Join(t.TempDir(), "config.toml"))
+	useFakeRegistry(t, fakeRegistry{"zod": "3.23.8", "chalk": "5.3.0", "left-pad": "1.3.0"})
+	script := filepath.Join(t.TempDir(), "s.ts")
+	orig := "// /// script\n// {\"dependencies\": {\"chalk\": \"4.1.2\", \"left-pad\": \"1.3.0\", \"zod\": \"^3.20.0\"}}\n// ///\nconsole.log(1)\n"
+	if err := os.WriteFile(script, []byte(orig), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	upgrade := func(dryRun bool, names ...string) map[string]string {
+		t.Helper()
+		flags := upgradeCmd.Flags()
+		flags.Set("script", script)
+		flags.Set("dry-run", strconv.FormatBool(dryRun))
+		defer flags.Set("script", "")
+		defer flags.Set("dry-run", "false")
+		upgradeCmd.Run(upgradeCmd, names)
+		deps, err := extractDependenciesFromHeader(script)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return deps
+	}
+
+	upgrade(true)
+	if data, _ := os.ReadFile(script); string(data) != orig {
+		t.Errorf("--dry-run changed the script:\n%s", data)
+	}
+
+	want := map[string]string{"chalk": "5.3.0", "left-pad": "1.3.0", "zod": "^3.20.0"}
+	if got := upgrade(false, "chalk"); !maps.Equal(got, want) {
+		t.Errorf("after upgrading chalk, dependencies = %v, want %v", got, want)
+	}
+
+	want["zod"] = "^3.23.8"
+	if got := upgrade(false); !maps.Equal(got, want) {
+		t.Errorf("after upgrading everything, dependencies = %v, want %v", got, want)
+	}
+	if data, _ := os.ReadFile(script); !strings.HasSuffix(string(data), "// ///\nconsole.log(1)\n") {
+		t.Errorf("upgrade changed the script body:\n%s", data)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// setUmask does nothing, since Windows has no umask.
+func setUmask(mask int) int {
+	return 0
+}
+
+// setProcessGroup makes cmd start in a process group of its own.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+}
+
+// signalProcessGroup stops the process pid.
+func signalProcessGroup(pid int, sig syscall.Signal) error {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	return p.Kill()
+}
+
+// processGroupExists reports false; signalProcessGroup has already killed the process.
+func processGroupExists(pid int) bool {
+	return false
+}
+
+// maxRSSBytes returns 0: Windows doesn't report the peak resident set size.
+func maxRSSBytes(state *os.ProcessState) int64 {
+	return 0
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// lockfileNames are the lockfiles bun may write into a cache directory.
+var lockfileNames = []string{"bun.lock", "bun.lockb"}
+
+// DependencyReport records what a run resolved and installed.
+type DependencyReport struct {
+	Script       string       `json:"script"`
+	Dependencies Dependencies `json:"dependencies"`
+	CacheHash    string       `json:"cacheHash"`
+	CacheDir     string       `json:"cacheDir"`
+	BunVersion   string       `json:"bunVersion"`
+	Lockfile     string       `json:"lockfile,omitempty"`
+	LockfileHash string       `json:"lockfileHash,omitempty"`
+	StartedAt    time.Time    `json:"startedAt"`
+	FinishedAt   time.Time    `json:"finishedAt"`
+}
+
+func newDependencyReport(scriptPath, bunPath string, deps Dependencies, depHash, cacheDir string, startedAt time.Time) *DependencyReport {
+	report := &DependencyReport{
+		Script:       scriptPath,
+		Dependencies: deps,
+		CacheHash:    depHash,
+		CacheDir:     cacheDir,
+		BunVersion:   getBunVersion(bunPath),
+		StartedAt:    startedAt.UTC(),
+		FinishedAt:   time.Now().UTC(),
+	}
+	if lockfile := findLockfile(cacheDir); lockfile != "" {
+		if hash, err := hashFile(lockfile); err == nil {
+			report.Lockfile = filepath.Base(lockfile)
+			report.LockfileHash = hash
+		}
+	}
+	return report
+}
+
+func (r *DependencyReport) WriteFile(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// RunMetrics records timing and resource usage of a run.
+type RunMetrics struct {
+	Script           string  `json:"script"`
+	CacheHash        string  `json:"cacheHash"`
+	Cached           bool    `json:"cached"`
+	InstallSeconds   float64 `json:"installSeconds"`
+	WallSeconds      float64 `json:"wallSeconds"`
+	ExitCode         int     `json:"exitCode"`
+	Signal           string  `json:"signal,omitempty"`
+	UserCPUSeconds   float64 `json:"userCpuSeconds,omitempty"`
+	SystemCPUSeconds float64 `json:"systemCpuSeconds,omitempty"`
+	MaxRSSBytes      int64   `json:"maxRssBytes,omitempty"`
+}
+
+func newRunMetrics(scriptPath, depHash string, cached bool, install, wall time.Duration, state *os.ProcessState) *RunMetrics {
+	m := &RunMetrics{
+		Script:         scriptPath,
+		CacheHash:      depHash,
+		Cached:         cached,
+		InstallSeconds: install.Seconds(),
+		WallSeconds:    wall.Seconds(),
+	}
+	if state == nil {
+		return m
+	}
+	var signal syscall.Signal
+	m.ExitCode, signal = exitStatus(state)
+	if signal != 0 {
+		m.Signal = signal.String()
+	}
+	m.UserCPUSeconds = state.UserTime().Seconds()
+	m.SystemCPUSeconds = state.SystemTime().Seconds()
+	m.MaxRSSBytes = maxRSSBytes(state)
+	return m
+}
+
+func (m *RunMetrics) WriteFile(path string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// getBunVersion returns the output of 'bun --version', or "" if it fails.
+func getBunVersion(bunPath string) string {
+	out, err := exec.Command(bunPath, "--version").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+var bunVersion struct {
+	once    sync.Once
+	version string
+}
+
+// installedBunVersion returns the version of the bun on PATH, or "" if it can't be run.
+func installedBunVersion() string {
+	bunVersion.once.Do(func() {
+		if bunPath, err := ensureBun(); err == nil {
+			bunVersion.version = getBunVersion(bunPath)
+		}
+	})
+	return bunVersion.version
+}
+
+func hashFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(data)), nil
+}
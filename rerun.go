@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// lastRun is the invocation recorded for 'bunv run --rerun-last'.
+type lastRun struct {
+	Dir  string   `json:"dir"`
+	Args []string `json:"args"`
+}
+
+func lastRunPath() string {
+	return filepath.Join(getCacheRoot(), "last-run.json")
+}
+
+// internalRunEnv marks a 'bunv run' started by bunv itself.
+const internalRunEnv = "BUNV_INTERNAL_RUN"
+
+// internalRunCommand returns a command running bunv with args as an internal run.
+func internalRunCommand(self string, args ...string) *exec.Cmd {
+	cmd := exec.Command(self, args...)
+	cmd.Env = append(os.Environ(), internalRunEnv+"=1")
+	return cmd
+}
+
+// shouldRecordRun reports whether 'bunv run args' should be saved for --rerun-last.
+func shouldRecordRun(args []string) bool {
+	if dryRun || dependencyHashOnly || printTreeHash || prewarmTypes || emitRunScript != "" {
+		return false
+	}
+	return os.Getenv(internalRunEnv) == "" && !(len(args) > 0 && isInlineScript(args[0]))
+}
+
+// recordLastRun saves the current command line and working directory.
+func recordLastRun() error {
+	dir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(lastRun{Dir: dir, Args: os.Args[1:]})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(getCacheRoot(), cacheDirPerm); err != nil {
+		return err
+	}
+	return os.WriteFile(lastRunPath(), data, cacheFilePerm)
+}
+
+func readLastRun() (*lastRun, error) {
+	data, err := os.ReadFile(lastRunPath())
+	if err != nil {
+		return nil, err
+	}
+	var run lastRun
+	if err := json.Unmarshal(data, &run); err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+var shellSafeRe = regexp.MustCompile(`^[A-Za-z0-9@%+=:,./_-]+$`)
+
+// shellQuote joins args into a command line that a POSIX shell splits back into the same arguments.
+func shellQuote(args []string) string {
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		if shellSafeRe.MatchString(a) {
+			quoted[i] = a
+		} else {
+			quoted[i] = "'" + strings.ReplaceAll(a, "'", `'\''`) + "'"
+		}
+	}
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestShouldRecordRun(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	inline := filepath.Join(getCacheRoot(), ".exec-123.ts")
+	tests := []struct {
+		name string
+		flag *bool
+		env  string
+		args []string
+		want bool
+	}{
+		{name: "script", args: []string{"s.ts"}, want: true},
+		{name: "dry run", flag: &dryRun, args: []string{"s.ts"}},
+		{name: "dependency hash", flag: &dependencyHashOnly, args: []string{"s.ts"}},
+		{name: "tree hash", flag: &printTreeHash, args: []string{"s.ts"}},
+		{name: "prewarm types", flag: &prewarmTypes, args: []string{"s.ts"}},
+		{name: "internal run", env: "1", args: []string{"s.ts"}},
+		{name: "exec snippet", args: []string{inline}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.flag != nil {
+				*tt.flag = true
+				defer func() { *tt.flag = false }()
+			}
+			t.Setenv(internalRunEnv, tt.env)
+			if got := shouldRecordRun(tt.args); got != tt.want {
+				t.Errorf("shouldRecordRun = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	emitRunScript = "launcher.sh"
+	defer func() { emitRunScript = "" }()
+	if shouldRecordRun([]string{"s.ts"}) {
+		t.Error("recorded a run with --emit-run-script")
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// A dependencyProposal is one source's requested version of a package.
+type dependencyProposal struct {
+	Source  string `json:"source"`
+	Version string `json:"version"`
+}
+
+// A dependencyTrace records every version proposed for each package, lowest precedence first.
+type dependencyTrace map[string][]dependencyProposal
+
+func (t dependencyTrace) add(name, source, version string) {
+	if t != nil {
+		t[name] = append(t[name], dependencyProposal{source, version})
+	}
+}
+
+// addLowest records a proposal that every existing one takes precedence over.
+func (t dependencyTrace) addLowest(name, source, version string) {
+	if t != nil {
+		t[name] = append([]dependencyProposal{{source, version}}, t[name]...)
+	}
+}
+
+type packageResolution struct {
+	Proposals []dependencyProposal `json:"proposals"`
+	// Winner is the highest-precedence proposal of the version that was
+	// installed, or nil if the package was dropped (e.g. by --prewarm-types).
+	Winner *dependencyProposal `json:"winner"`
+}
+
+// writeResolutionReport writes the trace and the winning proposal for each package as JSON.
+func writeResolutionReport(path string, trace dependencyTrace, deps Dependencies) error {
+	packages := map[string]packageResolution{}
+	for name, proposals := range trace {
+		res := packageResolution{Proposals: proposals}
+		if version, ok := deps[name]; ok {
+			for i := len(proposals) - 1; i >= 0; i-- {
+				if proposals[i].Version == version {
+					res.Winner = &proposals[i]
+					break
+				}
+			}
+		}
+		packages[name] = res
+	}
+	data, err := json.MarshalIndent(map[string]any{"packages": packages}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
@@ -0,0 +1,963 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var runCmd = &cobra.Command{
+	Use:   "run [script.ts] [-- script-args...]",
+	Short: "Run a TypeScript file with optional dependencies",
+	Args: func(cmd *cobra.Command, args []string) error {
+		if rerunLast {
+			return cobra.NoArgs(cmd, args)
+		}
+		return cobra.MinimumNArgs(1)(cmd, args)
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		if rerunLast {
+			rerunLastRun()
+			return
+		}
+
+		applyConfig(cmd)
+
+		r := &scriptRun{args: args, startedAt: time.Now()}
+		defer r.cleanup()
+
+		// For a wider mode to take effect, including on the files bun
+		// install creates, the umask has to be relaxed to match until the
+		// cache directory is ready.
+		r.restoreUmask = func() {}
+		if cacheDirMode != "" {
+			var err error
+			if cacheDirPerm, cacheFilePerm, err = parseCacheDirMode(cacheDirMode); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: Invalid --cache-dir-mode: %v\n", err)
+				r.exit(1)
+			}
+			oldUmask := setUmask(int(0777 &^ cacheDirPerm))
+			r.restoreUmask = func() { setUmask(oldUmask) }
+		}
+
+		record := shouldRecordRun(args)
+		// Not passed on to the script, which may run bunv itself
+		os.Unsetenv(internalRunEnv)
+		if record {
+			if err := recordLastRun(); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: Could not record this run for --rerun-last: %v\n", err)
+			}
+		}
+
+		if watch {
+			if args[0] == "-" || isRemoteScript(args[0]) {
+				fmt.Fprintf(os.Stderr, "Error: --watch needs a local script file\n")
+				r.exit(1)
+			}
+			scriptFile, err := resolveScriptPath(args[0])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				r.exit(1)
+			}
+			if err := runWatch(scriptFile, withoutWatchFlag(interpreterArgs(os.Args[1:]))); err != nil {
+				fmt.Fprintf(os.Stderr, "Error watching %s: %v\n", scriptFile, err)
+				r.exit(1)
+			}
+			return
+		}
+
+		if r.resolve() && r.install() && r.prepare() {
+			r.launch()
+		}
+	},
+}
+
+// scriptRun carries a 'bunv run' through its resolve, install, prepare and launch stages.
+type scriptRun struct {
+	args       []string
+	scriptArgs []string
+	startedAt  time.Time
+
+	scriptFile    string
+	absScriptPath string
+	// Scripts from stdin or bunv exec are removed after the run, along
+	// with their link in the cache directory
+	temporaryScript bool
+
+	bunPath         string
+	bases           []string
+	fileEnv         [][2]string
+	deps            Dependencies
+	depHash         string
+	aliases         map[string]string
+	installRegistry string
+
+	cacheDir        string
+	nodeModulesPath string
+	needInstall     bool
+	installDuration time.Duration
+	releaseLock     func()
+	restoreUmask    func()
+
+	scriptPath   string
+	copiedAssets []string
+	env          []string
+	scriptEnv    [][2]string
+	sandboxDir   string
+
+	// cleanupMu guards cleanups, which a signal handler may run
+	cleanupMu sync.Mutex
+	cleanups  []func()
+}
+
+// onCleanup registers f to run when the run ends.
+func (r *scriptRun) onCleanup(f func()) {
+	r.cleanupMu.Lock()
+	defer r.cleanupMu.Unlock()
+	r.cleanups = append(r.cleanups, f)
+}
+
+// exit runs the cleanups and exits with code.
+func (r *scriptRun) exit(code int) {
+	r.cleanup()
+	os.Exit(code)
+}
+
+// cleanup runs the registered cleanups, most recent first.
+func (r *scriptRun) cleanup() {
+	r.cleanupMu.Lock()
+	cleanups := r.cleanups
+	r.cleanups = nil
+	r.cleanupMu.Unlock()
+	for i := len(cleanups) - 1; i >= 0; i-- {
+		cleanups[i]()
+	}
+}
+
+// rerunLastRun replaces this process with the previous recorded run, or prints it with --edit.
+func rerunLastRun() {
+	last, err := readLastRun()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: No previous run recorded: %v\n", err)
+		os.Exit(1)
+	}
+	if editLast {
+		fmt.Printf("cd %s && bunv %s\n", shellQuote([]string{last.Dir}), shellQuote(last.Args))
+		return
+	}
+	self, err := os.Executable()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error finding bunv executable: %v\n", err)
+		os.Exit(1)
+	}
+	if err := os.Chdir(last.Dir); err != nil {
+		fmt.Fprintf(os.Stderr, "Error changing to %s: %v\n", last.Dir, err)
+		os.Exit(1)
+	}
+	err = syscall.Exec(self, append([]string{os.Args[0]}, last.Args...), os.Environ())
+	fmt.Fprintf(os.Stderr, "Error executing bunv: %v\n", err)
+	os.Exit(1)
+}
+
+// resolve finds the script and works out its dependencies and cache key.
+func (r *scriptRun) resolve() bool {
+	args := r.args
+	for _, a := range installArgs {
+		if _, err := splitArgs(a); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Invalid --install-args %q: %v\n", a, err)
+			r.exit(1)
+		}
+	}
+	// --dependency-hash-only and --dry-run leave the cache untouched, so
+	// a script from stdin or a URL is kept in a temporary directory
+	// instead
+	scriptRoot := getCacheRoot()
+	if (dependencyHashOnly || dryRun) && (args[0] == "-" || isRemoteScript(args[0])) {
+		tempRoot, err := os.MkdirTemp("", "bunv-")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating temporary directory: %v\n", err)
+			r.exit(1)
+		}
+		r.onCleanup(func() { os.RemoveAll(tempRoot) })
+		scriptRoot = tempRoot
+	}
+
+	scriptFromStdin := args[0] == "-"
+	var err error
+	if scriptFromStdin {
+		r.scriptFile, err = readScriptFromStdin(scriptRoot)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading script from stdin: %v\n", err)
+			r.exit(1)
+		}
+		scriptFile := r.scriptFile
+		r.onCleanup(func() { os.Remove(scriptFile) })
+	} else if isRemoteScript(args[0]) {
+		if noRemote {
+			fmt.Fprintf(os.Stderr, "Error: Running remote scripts is disabled by --no-remote\n")
+			r.exit(1)
+		}
+		if offline {
+			fmt.Fprintf(os.Stderr, "Error: Can't fetch %s with --offline\n", args[0])
+			r.exit(1)
+		}
+		if r.scriptFile, err = fetchRemoteScript(args[0], scriptRoot); err != nil {
+			fmt.Fprintf(os.Stderr, "Error fetching script: %v\n", err)
+			r.exit(1)
+		}
+		logf("Running %s\n", args[0])
+	} else if r.scriptFile, err = resolveScriptPath(args[0]); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		r.exit(1)
+	}
+	scriptFile := r.scriptFile
+	r.scriptArgs = args[1:]
+	r.temporaryScript = scriptFromStdin || isInlineScript(scriptFile)
+
+	if runMode != "" && runMode != "development" && runMode != "production" {
+		fmt.Fprintf(os.Stderr, "Error: --mode must be development or production\n")
+		r.exit(1)
+	}
+
+	if _, err := os.Stat(scriptFile); os.IsNotExist(err) {
+		fmt.Fprintf(os.Stderr, "Error: File %s does not exist\n", scriptFile)
+		r.exit(1)
+	}
+	if !isScriptFile(scriptFile) {
+		fmt.Fprintf(os.Stderr, "Warning: %s is not a %s file; bun may not run it, and it can't carry a metadata block\n", scriptFile, strings.Join(scriptExtensions, "/"))
+	}
+	// A malformed block would otherwise run the script without its
+	// dependencies, failing later with confusing import errors
+	var metaErr *metadataError
+	if _, err := extractHeader(scriptFile); errors.As(err, &metaErr) {
+		if strictMetadata {
+			fmt.Fprintf(os.Stderr, "Error: Malformed metadata block in %s: %v\n", scriptFile, metaErr)
+			r.exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "Warning: Ignoring malformed metadata block in %s: %v\n", scriptFile, metaErr)
+	}
+
+	if verifyScriptHash != "" {
+		actual, err := hashFile(scriptFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error hashing script: %v\n", err)
+			r.exit(1)
+		}
+		if !strings.EqualFold(actual, strings.TrimPrefix(verifyScriptHash, "sha256:")) {
+			fmt.Fprintf(os.Stderr, "Error: %s does not match the expected hash\n  expected: %s\n  actual:   %s\n", scriptFile, verifyScriptHash, actual)
+			r.exit(1)
+		}
+	}
+
+	if r.bunPath, err = ensureBun(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		r.exit(exitBunNotFound)
+	}
+
+	if mergeEnvFrom != "" {
+		if _, err := os.Stat(mergeEnvFrom); os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "Error: File %s does not exist\n", mergeEnvFrom)
+			r.exit(1)
+		}
+		if sameFile(mergeEnvFrom, scriptFile) {
+			fmt.Fprintf(os.Stderr, "Error: %s cannot merge from itself\n", scriptFile)
+			r.exit(1)
+		}
+		r.bases = append(r.bases, mergeEnvFrom)
+	}
+
+	for _, path := range envFiles {
+		vars, err := parseEnvFile(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading env file: %v\n", err)
+			r.exit(1)
+		}
+		r.fileEnv = append(r.fileEnv, vars...)
+	}
+
+	var trace dependencyTrace
+	if resolutionReportPath != "" {
+		trace = dependencyTrace{}
+	}
+	deps := resolveDependencies(scriptFile, defaultEngine, trace, r.bases...)
+	if failOnEmptyDeps {
+		declared := 0
+		for name := range deps {
+			if name != "@types/node" {
+				declared++
+			}
+		}
+		if declared == 0 {
+			fmt.Fprintf(os.Stderr, "Error: %s declares no dependencies; is its '// /// script' block missing or malformed?\n", scriptFile)
+			r.exit(1)
+		}
+	}
+	if dedupeWith != "" {
+		if _, err := os.Stat(dedupeWith); os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "Error: File %s does not exist\n", dedupeWith)
+			r.exit(1)
+		}
+		otherDeps := getDependencies(dedupeWith, defaultEngine)
+		var conflicts []string
+		deps, conflicts = deps.Union(otherDeps)
+		for k, v := range otherDeps {
+			trace.addLowest(k, dedupeWith, v)
+		}
+		for _, c := range conflicts {
+			fmt.Fprintf(os.Stderr, "Warning: %s is %s in %s but %s in %s; using %s\n",
+				c, deps[c], scriptFile, otherDeps[c], dedupeWith, deps[c])
+		}
+	}
+	if dependencyBlocklist == "" {
+		dependencyBlocklist = os.Getenv("BUNV_DEPENDENCY_BLOCKLIST")
+	}
+	if dependencyBlocklist != "" {
+		patterns, err := readBlocklist(dependencyBlocklist)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading dependency blocklist: %v\n", err)
+			r.exit(1)
+		}
+		if name, pattern := deps.Blocked(patterns); name != "" {
+			fmt.Fprintf(os.Stderr, "Error: %s is blocked by %q in %s (required by %s)\n",
+				name, pattern, dependencyBlocklist, dependencySource(name, scriptFile))
+			r.exit(1)
+		}
+	}
+	if r.installRegistry, err = scriptRegistry(scriptFile); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		r.exit(1)
+	}
+	if r.absScriptPath, err = filepath.Abs(scriptFile); err != nil {
+		fmt.Fprintf(os.Stderr, "Error getting absolute path: %v\n", err)
+		r.exit(1)
+	}
+	if resolveSymlinks {
+		if r.absScriptPath, err = filepath.EvalSymlinks(r.absScriptPath); err != nil {
+			fmt.Fprintf(os.Stderr, "Error resolving symlinks: %v\n", err)
+			r.exit(1)
+		}
+	}
+
+	if r.aliases, err = getAliases(scriptFile, filepath.Dir(r.absScriptPath)); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		r.exit(1)
+	}
+	r.depHash = deps.CacheKey(installedBunVersion(), r.installRegistry, r.aliases)
+	if prewarmTypes {
+		deps = deps.TypesOnly()
+		r.depHash = "types-" + deps.CacheKey(installedBunVersion(), r.installRegistry, nil)
+	}
+	r.deps = deps
+	debugf("cache key %s for %d dependencies (bun %s)\n", r.depHash, len(deps), installedBunVersion())
+	if resolutionReportPath != "" {
+		if err := writeResolutionReport(resolutionReportPath, trace, deps); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing dependency resolution report: %v\n", err)
+			r.exit(1)
+		}
+	}
+	if dependencyHashOnly {
+		fmt.Println(r.depHash)
+		return false
+	}
+	r.cacheDir = getCacheDir(r.depHash)
+	r.nodeModulesPath = filepath.Join(r.cacheDir, "node_modules")
+	if dryRun {
+		cacheDir := r.cacheDir
+		cold := noCache || refresh || len(uninstalledPackages(deps, r.nodeModulesPath)) > 0
+		if noCache {
+			// The name MkdirTemp will pick below, up to its random part
+			cacheDir = filepath.Join(os.TempDir(), "bunv-run-*")
+		}
+		printPlan(os.Stdout, deps, cacheDir, cold)
+		scriptPath := filepath.Join(cacheDir, filepath.Base(r.absScriptPath))
+		fmt.Printf("Run: %s\n", shellQuote(append([]string{r.bunPath}, runArgv(scriptFile, scriptPath, r.scriptArgs)...)))
+		return false
+	}
+	return true
+}
+
+// install makes sure the cache entry has the script's dependencies installed.
+func (r *scriptRun) install() bool {
+	scriptFile, deps := r.scriptFile, r.deps
+	if offline {
+		if missing := uninstalledPackages(deps, r.nodeModulesPath); len(missing) > 0 {
+			fmt.Fprintf(os.Stderr, "Error: --offline needs these packages installed in %s, but they are missing: %s\n", r.cacheDir, strings.Join(missing, ", "))
+			fmt.Fprintf(os.Stderr, "Run the script once without --offline while online to install them.\n")
+			r.exit(1)
+		}
+	}
+	if noCache {
+		cacheDir, err := os.MkdirTemp("", "bunv-run-")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating temporary directory: %v\n", err)
+			r.exit(1)
+		}
+		r.onCleanup(func() { os.RemoveAll(cacheDir) })
+		r.cacheDir = cacheDir
+		r.nodeModulesPath = filepath.Join(cacheDir, "node_modules")
+		r.needInstall = true
+	}
+	cacheDir, nodeModulesPath := r.cacheDir, r.nodeModulesPath
+
+	if printInstallPlan {
+		cold := len(uninstalledPackages(deps, nodeModulesPath)) > 0
+		printPlan(os.Stderr, deps, cacheDir, cold)
+		if cold && !assumeYes && isInteractive() && !confirm("Proceed with install?") {
+			fmt.Fprintf(os.Stderr, "Aborted\n")
+			r.exit(1)
+		}
+	}
+
+	// A --no-cache directory is private to this run and needs no lock.
+	r.releaseLock = func() {}
+	if !noCache {
+		var err error
+		if r.releaseLock, err = acquireInstallLock(cacheDir); err != nil {
+			fmt.Fprintf(os.Stderr, "Error locking cache directory: %v\n", err)
+			r.exit(1)
+		}
+		r.onCleanup(r.releaseLock)
+	}
+
+	if _, err := os.Stat(cacheDir); os.IsNotExist(err) {
+		r.needInstall = true
+		if err := os.MkdirAll(cacheDir, cacheDirPerm); err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating cache directory: %v\n", err)
+			r.exit(1)
+		}
+	}
+
+	if refresh && !r.needInstall {
+		r.needInstall = true
+		paths := []string{nodeModulesPath}
+		for _, name := range lockfileNames {
+			paths = append(paths, filepath.Join(cacheDir, name))
+		}
+		for _, path := range paths {
+			if err := os.RemoveAll(path); err != nil {
+				fmt.Fprintf(os.Stderr, "Error removing %s: %v\n", path, err)
+				r.exit(1)
+			}
+		}
+	}
+
+	if deterministic {
+		if err := checkDeterministic(deps, cacheDir); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			r.exit(1)
+		}
+	}
+
+	if r.needInstall {
+		debugf("cache miss: %s\n", cacheDir)
+		runCacheHook(onMissHook, r.depHash, cacheDir, scriptFile)
+		if err := writePackageJSON(cacheDir, deps, devDependencyNames(scriptFile)); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing package.json: %v\n", err)
+			r.exit(1)
+		}
+	} else {
+		debugf("cache hit: %s\n", cacheDir)
+		runCacheHook(onHitHook, r.depHash, cacheDir, scriptFile)
+	}
+
+	if keepPackageJSON != "" {
+		if err := copyFile(filepath.Join(cacheDir, "package.json"), keepPackageJSON, 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing package.json copy: %v\n", err)
+			r.exit(1)
+		}
+	}
+
+	if _, err := os.Stat(nodeModulesPath); err == nil && strictDeps {
+		if mismatches := installedMismatches(deps, nodeModulesPath); len(mismatches) > 0 {
+			for _, m := range mismatches {
+				fmt.Fprintf(os.Stderr, "Warning: %s\n", m)
+			}
+			logf("Reinstalling packages to match declared versions\n")
+			if err := os.RemoveAll(nodeModulesPath); err != nil {
+				fmt.Fprintf(os.Stderr, "Error removing stale install: %v\n", err)
+				r.exit(1)
+			}
+		}
+	}
+	var savedLockfile string
+	if frozen {
+		savedLockfile = siblingLockfile(scriptFile)
+	}
+	if savedLockfile != "" {
+		changed, err := restoreLockfile(savedLockfile, cacheDir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error restoring %s: %v\n", savedLockfile, err)
+			r.exit(1)
+		}
+		if changed {
+			// Reinstall so node_modules matches the restored lockfile
+			if err := os.RemoveAll(nodeModulesPath); err != nil {
+				fmt.Fprintf(os.Stderr, "Error removing stale install: %v\n", err)
+				r.exit(1)
+			}
+		}
+	}
+	if missing := uninstalledPackages(deps, nodeModulesPath); len(missing) > 0 {
+		debugf("not installed: %s\n", strings.Join(missing, ", "))
+		if offline {
+			// Reached when --strict-deps or --frozen discarded the install
+			fmt.Fprintf(os.Stderr, "Error: --offline can't reinstall %s in %s\n", strings.Join(missing, ", "), cacheDir)
+			r.exit(1)
+		}
+		r.runInstall()
+		if frozen && savedLockfile == "" {
+			saved, err := saveLockfile(scriptFile, cacheDir)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error saving lockfile: %v\n", err)
+				r.exit(1)
+			}
+			logf("Saved lockfile to %s\n", saved)
+		}
+	}
+
+	if len(verifyBins) > 0 {
+		if missing := missingBins(nodeModulesPath, verifyBins); len(missing) > 0 {
+			fmt.Fprintf(os.Stderr, "Error: Expected binaries not found in %s: %s\n", filepath.Join(nodeModulesPath, ".bin"), strings.Join(missing, ", "))
+			r.exit(1)
+		}
+	}
+
+	if saveExact {
+		saveWith = true
+	}
+	if saveWith && (r.temporaryScript || isRemoteScript(r.args[0])) {
+		fmt.Fprintf(os.Stderr, "Warning: --save only applies to local script files\n")
+		saveWith = false
+	}
+
+	if pinVersions {
+		if r.temporaryScript || isRemoteScript(r.args[0]) {
+			fmt.Fprintf(os.Stderr, "Warning: --pin only applies to local script files\n")
+		} else if changes, err := pinDependencies(scriptFile, nodeModulesPath); err != nil {
+			fmt.Fprintf(os.Stderr, "Error pinning dependencies: %v\n", err)
+			r.exit(1)
+		} else {
+			for _, change := range changes {
+				logf("Pinned %s\n", change)
+			}
+		}
+	}
+
+	if writeSBOMPath != "" {
+		if err := writeSBOM(writeSBOMPath, scriptFile, cacheDir); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing SBOM: %v\n", err)
+			r.exit(1)
+		}
+	}
+
+	if printTreeHash {
+		fmt.Println(treeHash(deps, cacheDir))
+		return false
+	}
+
+	if prewarmTypes {
+		fmt.Println(cacheDir)
+		return false
+	}
+	return true
+}
+
+// writePackageJSON writes the package.json bun installs from.
+func writePackageJSON(cacheDir string, deps Dependencies, devNames map[string]bool) error {
+	depEntries := []string{}
+	devEntries := []string{}
+	for k, v := range deps {
+		entry := fmt.Sprintf("\"%s\": \"%s\"", k, v)
+		if devNames[k] {
+			devEntries = append(devEntries, entry)
+		} else {
+			depEntries = append(depEntries, entry)
+		}
+	}
+	sort.Strings(depEntries)
+	sort.Strings(devEntries)
+	devSection := ""
+	if len(devEntries) > 0 {
+		devSection = fmt.Sprintf(",\n  \"devDependencies\": {\n    %s\n  }", strings.Join(devEntries, ",\n    "))
+	}
+	packageJSON := fmt.Sprintf(packageJSONTemplate, strings.Join(depEntries, ",\n    "), devSection)
+
+	var prettyJSON bytes.Buffer
+	if err := json.Indent(&prettyJSON, []byte(packageJSON), "", "  "); err != nil {
+		return fmt.Errorf("formatting package.json as JSON: %w", err)
+	}
+	return os.WriteFile(filepath.Join(cacheDir, "package.json"), prettyJSON.Bytes(), cacheFilePerm)
+}
+
+// runInstall runs bun install in the cache entry, retrying as --install-retries allows.
+func (r *scriptRun) runInstall() {
+	cacheDir := r.cacheDir
+	logf("Installing packages...\n")
+	if r.installRegistry != "" {
+		if err := writeBunfig(cacheDir, r.installRegistry); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing bunfig.toml: %v\n", err)
+			r.exit(1)
+		}
+	}
+	installStartedAt := time.Now()
+	var installOutput bytes.Buffer
+	var installArgs []string
+	var truncated bool
+	var installErr error
+	for attempt := 0; ; attempt++ {
+		installOutput.Reset()
+		installArgs, truncated, installErr = runInstall(r.bunPath, cacheDir, &installOutput)
+		if installErr == nil || attempt >= installRetries {
+			break
+		}
+		delay := time.Second << attempt
+		fmt.Fprintf(os.Stderr, "Warning: bun install failed: %v; retrying in %s (%d of %d)\n", installErr, delay, attempt+1, installRetries)
+		// Start the next attempt from a clean slate
+		os.RemoveAll(r.nodeModulesPath)
+		time.Sleep(delay)
+	}
+	if quiet && installErr != nil {
+		fmt.Fprintf(os.Stderr, "%s", installOutput.String())
+	} else if truncated {
+		if installErr != nil {
+			fmt.Fprintf(os.Stderr, "Full install output:\n%s", installOutput.String())
+		} else {
+			total := strings.Count(strings.TrimRight(installOutput.String(), "\n"), "\n") + 1
+			logf("... (%d lines omitted)\n", total-maxInstallOutputLines)
+		}
+	}
+	if installErr != nil {
+		fmt.Fprintf(os.Stderr, "Error installing packages: %v\n", installErr)
+		// The full output was just printed when quiet or truncated
+		if !quiet && !truncated {
+			if tail := lastLines(installOutput.String(), installFailureTail); len(tail) > 0 {
+				fmt.Fprintf(os.Stderr, "Last lines of bun install output:\n  %s\n", strings.Join(tail, "\n  "))
+			}
+		}
+		fmt.Fprintf(os.Stderr, "package.json: %s\n", filepath.Join(cacheDir, "package.json"))
+		fmt.Fprintf(os.Stderr, "To inspect or retry: cd %s && %s\n", shellQuote([]string{cacheDir}), shellQuote(installArgs))
+		r.exit(1)
+	}
+	r.installDuration = time.Since(installStartedAt)
+	debugf("installed in %s\n", r.installDuration.Round(time.Millisecond))
+	if failOnInstallWarning {
+		if warning, err := findInstallWarning(installOutput.String(), installWarningPatterns); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Invalid install warning pattern: %v\n", err)
+			r.exit(1)
+		} else if warning != "" {
+			// Drop the install so the next run reports the warning again
+			os.RemoveAll(r.nodeModulesPath)
+			fmt.Fprintf(os.Stderr, "Error: Install produced a warning: %s\n", warning)
+			r.exit(1)
+		}
+	}
+}
+
+// prepare links the script and its assets into the cache entry and builds its environment.
+func (r *scriptRun) prepare() bool {
+	scriptFile, cacheDir := r.scriptFile, r.cacheDir
+	scriptBase := filepath.Base(r.absScriptPath)
+	r.scriptPath = filepath.Join(cacheDir, scriptBase)
+	os.Remove(r.scriptPath)
+	if err := os.Link(r.absScriptPath, r.scriptPath); err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating hardlink to script file: %v\n", err)
+		r.exit(1)
+	}
+	if r.temporaryScript {
+		scriptPath := r.scriptPath
+		r.onCleanup(func() { os.Remove(scriptPath) })
+	}
+
+	if failOnMissingTypes {
+		missing, err := findMissingTypes(r.bunPath, cacheDir, r.scriptPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error checking types: %v\n", err)
+			r.exit(1)
+		}
+		if len(missing) > 0 {
+			fmt.Fprintf(os.Stderr, "Error: No type declarations found for: %s\n", strings.Join(missing, ", "))
+			fmt.Fprintf(os.Stderr, "Add the matching @types/* packages or dependencies that ship their own types\n")
+			r.exit(1)
+		}
+	}
+
+	if err := writeAliasConfig(cacheDir, r.aliases); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing alias configuration: %v\n", err)
+		r.exit(1)
+	}
+
+	copiedAssets, err := copyAssets(filepath.Dir(r.absScriptPath), cacheDir, assetPatterns, scriptBase)
+	if len(copiedAssets) > 0 && !keepTemp {
+		r.onCleanup(func() {
+			for _, asset := range copiedAssets {
+				os.Remove(asset)
+			}
+		})
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error copying assets: %v\n", err)
+		r.exit(1)
+	}
+	r.copiedAssets = copiedAssets
+
+	r.releaseLock()
+	r.restoreUmask()
+
+	// A sandboxed script inherits only allowlisted variables, and gets a
+	// private directory to write to
+	env := os.Environ()
+	if useSandbox {
+		if r.sandboxDir, err = os.MkdirTemp("", "bunv-sandbox-"); err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating sandbox directory: %v\n", err)
+			r.exit(1)
+		}
+		sandboxDir := r.sandboxDir
+		r.onCleanup(func() { os.RemoveAll(sandboxDir) })
+		env = sandboxEnv(env, r.sandboxDir)
+	}
+	inheritedEnv := env
+
+	// Set NODE_PATH to the cacheDir, plus any existing NODE_PATH
+	nodePathSet := false
+	for i, v := range env {
+		if strings.HasPrefix(v, "NODE_PATH=") {
+			env[i] = fmt.Sprintf("NODE_PATH=%s%c%s", cacheDir, os.PathListSeparator, v[len("NODE_PATH="):])
+			nodePathSet = true
+			break
+		}
+	}
+	if !nodePathSet {
+		env = append(env, fmt.Sprintf("NODE_PATH=%s", cacheDir))
+	}
+
+	// Variables bunv sets for the script on top of the inherited ones
+	for _, envSource := range append(r.bases, scriptFile) {
+		headerEnv := extractEnvFromHeader(envSource)
+		names := make([]string, 0, len(headerEnv))
+		for k := range headerEnv {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		for _, k := range names {
+			r.scriptEnv = append(r.scriptEnv, [2]string{k, headerEnv[k]})
+		}
+	}
+	if runMode != "" {
+		r.scriptEnv = append(r.scriptEnv, [2]string{"NODE_ENV", runMode})
+	}
+	for _, kv := range r.scriptEnv {
+		env = setEnv(env, kv[0], kv[1])
+	}
+	// --env-file variables are applied on top of the header's but kept
+	// out of scriptEnv, so that secrets aren't written into launchers.
+	for _, kv := range r.fileEnv {
+		if envOverride && slices.ContainsFunc(inheritedEnv, func(v string) bool { return strings.HasPrefix(v, kv[0]+"=") }) {
+			continue
+		}
+		env = setEnv(env, kv[0], kv[1])
+	}
+	r.env = env
+
+	if emitRunScript != "" {
+		bunArgs := runArgv(scriptFile, r.scriptPath, r.scriptArgs)
+		if err := writeLauncher(emitRunScript, r.bunPath, cacheDir, r.scriptEnv, bunArgs); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing launcher: %v\n", err)
+			r.exit(1)
+		}
+		fmt.Printf("Wrote launcher to %s\n", emitRunScript)
+		return false
+	}
+	return true
+}
+
+// launch runs bun on the script, possibly inside an exec wrapper and a sandbox.
+func (r *scriptRun) launch() {
+	scriptFile, cacheDir, env := r.scriptFile, r.cacheDir, r.env
+	if minVersion := minBunVersion(scriptFile); minVersion != "" {
+		if err := checkBunVersion(r.bunPath, minVersion); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			r.exit(1)
+		}
+	}
+
+	// execPath and execArgs are what actually gets run: bun or a header
+	// script, possibly inside an exec wrapper and a sandbox.
+	execPath, execArgs := r.bunPath, runArgv(scriptFile, r.scriptPath, r.scriptArgs)
+	if scriptName != "" {
+		var err error
+		if execPath, execArgs, env, err = headerScriptCommand(scriptFile, scriptName, r.scriptArgs, cacheDir, env); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			r.exit(1)
+		}
+		if err := os.Chdir(cacheDir); err != nil {
+			fmt.Fprintf(os.Stderr, "Error changing to run directory: %v\n", err)
+			r.exit(1)
+		}
+	}
+
+	if execWrapper != "" {
+		wrapper, err := splitArgs(execWrapper)
+		if err == nil && len(wrapper) == 0 {
+			err = fmt.Errorf("empty command")
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Invalid --exec-wrapper %q: %v\n", execWrapper, err)
+			r.exit(1)
+		}
+		wrapperPath, err := exec.LookPath(wrapper[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error finding exec wrapper: %v\n", err)
+			r.exit(1)
+		}
+		execArgs = append(append(wrapper[1:], execPath), execArgs...)
+		execPath = wrapperPath
+	}
+
+	if useSandbox {
+		if err := os.Chdir(cacheDir); err != nil {
+			fmt.Fprintf(os.Stderr, "Error changing to run directory: %v\n", err)
+			r.exit(1)
+		}
+		if sb := findSandbox(); sb != nil {
+			// The script's directory and alias targets may sit in the hidden
+			// home, and so may bun behind an exec wrapper
+			readOnly := []string{filepath.Dir(r.absScriptPath), r.bunPath}
+			for _, path := range r.aliases {
+				readOnly = append(readOnly, path)
+			}
+			sort.Strings(readOnly[2:])
+			var argv []string
+			execPath, argv = sb.Wrap(execPath, execArgs, cacheDir, r.sandboxDir, readOnly)
+			execArgs = argv[1:]
+		} else {
+			fmt.Fprintf(os.Stderr, "Warning: No sandbox tool available (bwrap or sandbox-exec); only the environment and working directory are restricted\n")
+		}
+	}
+
+	var sidecars []*sidecar
+	stopWatchingSignals := func() {}
+	if parallelScripts {
+		var err error
+		if sidecars, err = extractSidecarsFromHeader(scriptFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			r.exit(1)
+		}
+		if err := startSidecars(sidecars, env); err != nil {
+			fmt.Fprintf(os.Stderr, "Error starting sidecars: %v\n", err)
+			r.exit(1)
+		}
+		r.onCleanup(func() { stopSidecars(sidecars) })
+		stopWatchingSignals = stopSidecarsOnSignal(sidecars, r.exit)
+	}
+
+	var memoryBytes uint64
+	if limitMemory != "" {
+		var err error
+		if memoryBytes, err = parseByteSize(limitMemory); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Invalid --limit-memory: %v\n", err)
+			r.exit(1)
+		}
+	}
+	limited := memoryBytes > 0 || limitCPU > 0
+	if limited && !resourceLimitsSupported {
+		fmt.Fprintf(os.Stderr, "Warning: Resource limits are not supported on %s; running without them\n", runtime.GOOS)
+		limited = false
+	}
+
+	// Work that has to happen after bun exits needs bun to run as a
+	// child process instead of replacing this one.
+	stdin := os.Stdin
+	if scriptStdin != "" && scriptStdin != "-" {
+		var err error
+		if stdin, err = os.Open(scriptStdin); err != nil {
+			fmt.Fprintf(os.Stderr, "Error opening script stdin: %v\n", err)
+			r.exit(1)
+		}
+	}
+
+	cleanupAssets := len(r.copiedAssets) > 0 && !keepTemp
+	debugf("running %s (%s after start)\n", shellQuote(append([]string{execPath}, execArgs...)), time.Since(r.startedAt).Round(time.Millisecond))
+	if cleanupAssets || dependencyReportPath != "" || retryOnCrash > 0 || stdin != os.Stdin || captureMetricsPath != "" || len(sidecars) > 0 || noCache || r.temporaryScript || saveWith || useSandbox {
+		// The limits are for bun alone, not bunv waiting on it
+		if limited {
+			var err error
+			if execPath, execArgs, err = limitedCommand(execPath, execArgs, memoryBytes, uint64(limitCPU)); err != nil {
+				fmt.Fprintf(os.Stderr, "Error finding bunv executable: %v\n", err)
+				r.exit(1)
+			}
+		}
+		startedAt := time.Now()
+		stopWatchingSignals()
+		state, runErr := runBun(execPath, execArgs, env, stdin)
+		exitCode, signal := exitStatus(state)
+		for attempt := 1; runErr == nil && signal != 0 && !relayedSignal.Load() && attempt <= retryOnCrash; attempt++ {
+			delay := time.Second << (attempt - 1)
+			fmt.Fprintf(os.Stderr, "bun crashed (%v), retrying in %s (%d/%d)...\n", signal, delay, attempt, retryOnCrash)
+			time.Sleep(delay)
+			if stdin != os.Stdin {
+				stdin.Seek(0, io.SeekStart)
+			}
+			state, runErr = runBun(execPath, execArgs, env, stdin)
+			exitCode, signal = exitStatus(state)
+		}
+		stopSidecars(sidecars)
+		if stdin != os.Stdin {
+			stdin.Close()
+		}
+		if runErr != nil {
+			fmt.Fprintf(os.Stderr, "Error executing bun: %v\n", runErr)
+			r.exit(1)
+		}
+		if exitCode == 0 && dependencyReportPath != "" {
+			report := newDependencyReport(r.absScriptPath, r.bunPath, r.deps, r.depHash, cacheDir, startedAt)
+			if err := report.WriteFile(dependencyReportPath); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing dependency report: %v\n", err)
+				r.exit(1)
+			}
+		}
+		if exitCode == 0 && saveWith {
+			saved, err := saveWithPackages(r.absScriptPath, r.nodeModulesPath, saveExact)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error saving --with packages: %v\n", err)
+				r.exit(1)
+			}
+			for _, pkg := range saved {
+				logf("Saved %s to %s\n", pkg, scriptFile)
+			}
+		}
+		if captureMetricsPath != "" {
+			metrics := newRunMetrics(r.absScriptPath, r.depHash, !r.needInstall, r.installDuration, time.Since(r.startedAt), state)
+			if err := metrics.WriteFile(captureMetricsPath); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing metrics: %v\n", err)
+				r.exit(1)
+			}
+		}
+		r.exit(exitCode)
+	}
+
+	// bun replaces this process, so it inherits limits set here
+	if limited {
+		if err := applyResourceLimits(memoryBytes, uint64(limitCPU)); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Resource limits not applied: %v\n", err)
+		}
+	}
+	execArgs = append([]string{execPath}, execArgs...)
+	if err := syscall.Exec(execPath, execArgs, env); err != nil {
+		fmt.Fprintf(os.Stderr, "Error executing bun: %v\n", err)
+		r.exit(1)
+	}
+}
@@ -0,0 +1,849 @@
+//go:build unix
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// fakeInstallerScript stands in for bun: install creates node_modules from
+// package.json, failing the first $FAKE_BUN_FAILURES times after leaving a
+// partial install behind, and writes $FAKE_BUN_LOCKFILE to bun.lock unless
+// the lockfile is frozen. run crashes the first $FAKE_BUN_CRASHES times,
+// then prints its arguments, $FAKE_ENV_* variables and the script, and
+// exits with $FAKE_BUN_EXIT.
+const fakeInstallerScript = `#!/bin/sh
+dir=$(dirname "$0")
+echo "$*" >> "$dir/calls.txt"
+case "$1" in
+--version) echo "${FAKE_BUN_VERSION:-1.1.0}" ;;
+install)
+	i=0
+	while [ "$i" -lt "${FAKE_BUN_OUTPUT_LINES:-0}" ]; do
+		echo "install line $i"
+		i=$((i + 1))
+	done
+	n=$(cat "$dir/failures" 2>/dev/null || echo 0)
+	if [ "$n" -lt "${FAKE_BUN_FAILURES:-0}" ]; then
+		echo $((n + 1)) > "$dir/failures"
+		mkdir -p node_modules/.partial
+		echo "error: fake install failure $((n + 1))"
+		exit 1
+	fi
+	sed -n 's/^    "\([^"]*\)": "\([^"]*\)",*$/\1 \2/p' package.json | while read -r name version; do
+		mkdir -p "node_modules/$name"
+		printf '{"name": "%s", "version": "%s"}\n' "$name" "${version#[~^]}" > "node_modules/$name/package.json"
+	done
+	if [ -n "$FAKE_BUN_LOCKFILE" ]; then
+		case " $* " in
+		*" --frozen-lockfile "*) ;;
+		*) echo "$FAKE_BUN_LOCKFILE" > bun.lock ;;
+		esac
+	fi ;;
+run)
+	shift
+	n=$(cat "$dir/crashes" 2>/dev/null || echo 0)
+	if [ "$n" -lt "${FAKE_BUN_CRASHES:-0}" ]; then
+		echo $((n + 1)) > "$dir/crashes"
+		kill -SEGV $$
+	fi
+	echo "RUN $*"
+	echo "NODE_PATH=$NODE_PATH"
+	env | grep '^FAKE_ENV_' | sort
+	for a in "$@"; do
+		case "$a" in *.ts) sed 's/^/SCRIPT: /' "$a" ;; esac
+	done
+	exit "${FAKE_BUN_EXIT:-0}" ;;
+esac
+`
+
+// bunvEnv is a fake bun, cache, home and temp directory to run bunv with.
+type bunvEnv struct {
+	dir      string
+	bunPath  string
+	cacheDir string
+	env      []string
+}
+
+// newBunvEnv sets up a bunvEnv in a temporary directory.
+func newBunvEnv(t *testing.T) *bunvEnv {
+	t.Helper()
+	dir := t.TempDir()
+	e := &bunvEnv{
+		dir:      dir,
+		bunPath:  filepath.Join(dir, "bin", "bun"),
+		cacheDir: filepath.Join(dir, "cache"),
+	}
+	for _, sub := range []string{"bin", "home", "tmp"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(e.bunPath, []byte(fakeInstallerScript), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	e.env = []string{
+		bunvTestMainEnv + "=1",
+		"PATH=" + os.Getenv("PATH"),
+		"HOME=" + filepath.Join(dir, "home"),
+		"TMPDIR=" + filepath.Join(dir, "tmp"),
+		"BUNV_BUN=" + e.bunPath,
+		"BUNV_CACHE_DIR=" + e.cacheDir,
+		"BUNV_CONFIG=" + filepath.Join(dir, "config.toml"),
+	}
+	return e
+}
+
+// script writes a script into the environment's directory and returns its path.
+func (e *bunvEnv) script(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(e.dir, name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// run runs the test binary as bunv, returning its combined output and exit code.
+func (e *bunvEnv) run(t *testing.T, stdin string, extraEnv []string, args ...string) (string, int) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Dir = e.dir
+	cmd.Env = append(append([]string{}, e.env...), extraEnv...)
+	cmd.Stdin = strings.NewReader(stdin)
+	out, err := cmd.CombinedOutput()
+	if _, ok := err.(*exec.ExitError); err != nil && !ok {
+		t.Fatal(err)
+	}
+	return string(out), cmd.ProcessState.ExitCode()
+}
+
+// calls returns the commands the fake bun was run with.
+func (e *bunvEnv) calls(t *testing.T) []string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(filepath.Dir(e.bunPath), "calls.txt"))
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSpace(string(data)), "\n")
+}
+
+// tempEntries returns the names in the environment's temp directory.
+func (e *bunvEnv) tempEntries(t *testing.T) []string {
+	t.Helper()
+	entries, err := os.ReadDir(filepath.Join(e.dir, "tmp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+const leftPadScript = "// /// script\n// {\"dependencies\": {\"left-pad\": \"1.3.0\"}}\n// ///\nconsole.log(1)\n"
+
+func TestNoCacheRemovesTempDir(t *testing.T) {
+	e := newBunvEnv(t)
+	script := e.script(t, "s.ts", leftPadScript)
+
+	out, code := e.run(t, "", nil, "run", "--no-cache", script)
+	if code != 0 || !strings.Contains(out, "RUN ") {
+		t.Fatalf("run exited with %d:\n%s", code, out)
+	}
+	if names := e.tempEntries(t); len(names) > 0 {
+		t.Errorf("temp directory left behind after a run: %q", names)
+	}
+
+	out, code = e.run(t, "", []string{"FAKE_BUN_FAILURES=1"}, "run", "--no-cache", script)
+	if code != 1 || !strings.Contains(out, "Error installing packages") {
+		t.Fatalf("run exited with %d, want a failed install:\n%s", code, out)
+	}
+	if names := e.tempEntries(t); len(names) > 0 {
+		t.Errorf("temp directory left behind after a failed install: %q", names)
+	}
+	if entries, _ := filepath.Glob(filepath.Join(e.cacheDir, "*", "package.json")); len(entries) > 0 {
+		t.Errorf("--no-cache installed into the cache: %q", entries)
+	}
+}
+
+func TestNoCacheRejectsLauncher(t *testing.T) {
+	e := newBunvEnv(t)
+	script := e.script(t, "s.ts", leftPadScript)
+	for _, flag := range []string{"--emit-run-script=launch.sh", "--prewarm-types"} {
+		out, code := e.run(t, "", nil, "run", "--no-cache", flag, script)
+		if code == 0 || !strings.Contains(out, "none of the others can be") {
+			t.Errorf("--no-cache %s exited with %d:\n%s", flag, code, out)
+		}
+	}
+}
+
+func TestRunFromStdin(t *testing.T) {
+	e := newBunvEnv(t)
+	stdinScripts := func() []string {
+		matches, _ := filepath.Glob(filepath.Join(e.cacheDir, ".stdin-*"))
+		links, _ := filepath.Glob(filepath.Join(e.cacheDir, "*", ".stdin-*"))
+		return append(matches, links...)
+	}
+
+	out, code := e.run(t, leftPadScript, nil, "run", "-", "--", "a", "b")
+	if code != 0 || !regexp.MustCompile(`RUN \S+/\.stdin-\d+\.ts a b\n`).MatchString(out) {
+		t.Fatalf("run exited with %d:\n%s", code, out)
+	}
+	if !strings.Contains(out, "NODE_PATH="+e.cacheDir+"/") {
+		t.Errorf("script didn't run from the cache:\n%s", out)
+	}
+	if left := stdinScripts(); len(left) > 0 {
+		t.Errorf("stdin script left behind: %q", left)
+	}
+
+	out, code = e.run(t, leftPadScript, []string{"FAKE_BUN_FAILURES=1"}, "run", "--refresh", "-")
+	if code != 1 {
+		t.Fatalf("run exited with %d, want a failed install:\n%s", code, out)
+	}
+	if left := stdinScripts(); len(left) > 0 {
+		t.Errorf("stdin script left behind after a failed install: %q", left)
+	}
+}
+
+func TestHeaderScriptLaunchesLikeBun(t *testing.T) {
+	e := newBunvEnv(t)
+	script := e.script(t, "s.ts", "// /// script\n// {\"scripts\": {\"show\": \"echo secret=$SECRET dir=$(pwd)\"}}\n// ///\n")
+	wrapper := e.script(t, "wrap.sh", "#!/bin/sh\necho wrapped\nexec \"$@\"\n")
+	if err := os.Chmod(wrapper, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	env := []string{"SECRET=hunter2"}
+
+	out, code := e.run(t, "", env, "run", "--script-name", "show", "--exec-wrapper", wrapper, script)
+	if code != 0 || !strings.Contains(out, "wrapped\nsecret=hunter2 dir="+e.cacheDir) {
+		t.Errorf("--exec-wrapper run exited with %d:\n%s", code, out)
+	}
+
+	out, code = e.run(t, "", env, "run", "--script-name", "show", "--sandbox", script)
+	if code != 0 || !strings.Contains(out, "secret= ") {
+		t.Errorf("--sandbox run exited with %d, want SECRET filtered out:\n%s", code, out)
+	}
+
+	out, code = e.run(t, "", nil, "run", "--script-name", "show", "--min-bun", "9.0", script)
+	if code != 1 || strings.Contains(out, "secret=") {
+		t.Errorf("run below --min-bun exited with %d:\n%s", code, out)
+	}
+
+	out, code = e.run(t, "", nil, "run", "--script-name", "missing", script)
+	if code != 1 || !strings.Contains(out, `script "missing" is not defined`) {
+		t.Errorf("missing script exited with %d:\n%s", code, out)
+	}
+}
+
+func TestCacheHooksFromConfig(t *testing.T) {
+	e := newBunvEnv(t)
+	script := e.script(t, "s.ts", leftPadScript)
+	log := filepath.Join(e.dir, "hooks.log")
+	config := "on-miss = \"echo miss $1 >> " + log + "\"\non-hit = \"echo hit $1 >> " + log + "\"\n"
+	if err := os.WriteFile(filepath.Join(e.dir, "config.toml"), []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for range 2 {
+		if out, code := e.run(t, "", nil, "run", script); code != 0 {
+			t.Fatalf("run exited with %d:\n%s", code, out)
+		}
+	}
+	// A flag overrides the config file
+	if out, code := e.run(t, "", nil, "run", "--on-hit", "echo flag >> "+log, script); code != 0 {
+		t.Fatalf("run exited with %d:\n%s", code, out)
+	}
+
+	data, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "miss ") || lines[1] != "hit"+strings.TrimPrefix(lines[0], "miss") || lines[2] != "flag" {
+		t.Errorf("hooks ran as:\n%s\nwant a miss, then a hit for the same hash, then the flag's hook", data)
+	}
+}
+
+func TestInstallRetries(t *testing.T) {
+	e := newBunvEnv(t)
+	script := e.script(t, "s.ts", leftPadScript)
+	env := []string{"FAKE_BUN_FAILURES=2"}
+
+	out, code := e.run(t, "", env, "run", "--install-retries", "2", script)
+	if code != 0 || !strings.Contains(out, "RUN ") {
+		t.Fatalf("run exited with %d:\n%s", code, out)
+	}
+	for _, want := range []string{"retrying in 1s (1 of 2)", "retrying in 2s (2 of 2)"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output is missing %q:\n%s", want, out)
+		}
+	}
+	installs := 0
+	for _, call := range e.calls(t) {
+		if strings.HasPrefix(call, "install") {
+			installs++
+		}
+	}
+	if installs != 3 {
+		t.Errorf("bun install ran %d times, want 3", installs)
+	}
+	matches, _ := filepath.Glob(filepath.Join(e.cacheDir, "*", "node_modules"))
+	if len(matches) != 1 {
+		t.Fatalf("node_modules = %q, want one", matches)
+	}
+	if _, err := os.Stat(filepath.Join(matches[0], ".partial")); err == nil {
+		t.Error("partial install from a failed attempt was not cleaned up")
+	}
+	if _, err := os.Stat(filepath.Join(matches[0], "left-pad", "package.json")); err != nil {
+		t.Errorf("dependency not installed: %v", err)
+	}
+}
+
+func TestInstallRetriesExhausted(t *testing.T) {
+	e := newBunvEnv(t)
+	script := e.script(t, "s.ts", leftPadScript)
+
+	out, code := e.run(t, "", []string{"FAKE_BUN_FAILURES=2"}, "run", "--install-retries", "1", script)
+	if code != 1 || strings.Contains(out, "RUN ") {
+		t.Fatalf("run exited with %d, want a failed install:\n%s", code, out)
+	}
+	if !strings.Contains(out, "fake install failure 2") || !strings.Contains(out, "Error installing packages") {
+		t.Errorf("last failure not reported:\n%s", out)
+	}
+}
+
+func TestRetryOnCrash(t *testing.T) {
+	e := newBunvEnv(t)
+	script := e.script(t, "s.ts", leftPadScript)
+	runs := func() int {
+		n := 0
+		for _, call := range e.calls(t) {
+			if strings.HasPrefix(call, "run") {
+				n++
+			}
+		}
+		return n
+	}
+
+	out, code := e.run(t, "", []string{"FAKE_BUN_CRASHES=2"}, "run", "--retry-on-crash", "2", script)
+	if code != 0 || !strings.Contains(out, "RUN ") {
+		t.Fatalf("run exited with %d:\n%s", code, out)
+	}
+	for _, want := range []string{"retrying in 1s (1/2)", "retrying in 2s (2/2)"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output is missing %q:\n%s", want, out)
+		}
+	}
+	if n := runs(); n != 3 {
+		t.Errorf("bun ran %d times, want 3", n)
+	}
+
+	os.Remove(filepath.Join(filepath.Dir(e.bunPath), "crashes"))
+	out, code = e.run(t, "", []string{"FAKE_BUN_CRASHES=5"}, "run", "--retry-on-crash", "1", script)
+	if code != 128+int(syscall.SIGSEGV) {
+		t.Errorf("run exited with %d after running out of retries, want the crash's status:\n%s", code, out)
+	}
+	if n := runs(); n != 5 {
+		t.Errorf("bun ran %d times in total, want 5", n)
+	}
+
+	// Ordinary failures are not retried
+	failing := e.script(t, "fail.ts", leftPadScript)
+	if err := os.WriteFile(e.bunPath, []byte(strings.Replace(fakeInstallerScript, `echo "RUN $*"`, "exit 3", 1)), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	out, code = e.run(t, "", nil, "run", "--retry-on-crash", "2", failing)
+	if code != 3 || strings.Contains(out, "retrying") {
+		t.Errorf("run exited with %d, want 3 without retries:\n%s", code, out)
+	}
+}
+
+func TestBunVersionSelectsCacheDir(t *testing.T) {
+	e := newBunvEnv(t)
+	script := e.script(t, "s.ts", leftPadScript)
+	nodePath := regexp.MustCompile(`NODE_PATH=([^:\n]+)`)
+	cacheDirFor := func(version string) string {
+		t.Helper()
+		out, code := e.run(t, "", []string{"FAKE_BUN_VERSION=" + version}, "run", script)
+		m := nodePath.FindStringSubmatch(out)
+		if code != 0 || m == nil {
+			t.Fatalf("run with bun %s exited with %d:\n%s", version, code, out)
+		}
+		return m[1]
+	}
+
+	old, upgraded := cacheDirFor("1.1.0"), cacheDirFor("1.2.0")
+	if old == upgraded {
+		t.Errorf("bun 1.1.0 and 1.2.0 share the cache directory %s", old)
+	}
+	for _, dir := range []string{old, upgraded} {
+		if filepath.Dir(dir) != e.cacheDir {
+			t.Errorf("cache directory %s is not under %s", dir, e.cacheDir)
+		}
+	}
+	if again := cacheDirFor("1.1.0"); again != old {
+		t.Errorf("bun 1.1.0 used %s, then %s", old, again)
+	}
+}
+
+func TestMergeEnvFrom(t *testing.T) {
+	e := newBunvEnv(t)
+	base := e.script(t, "base.ts", "// /// script\n// {\"env\": {\"FAKE_ENV_A\": \"base\", \"FAKE_ENV_B\": \"base\"}, \"dependencies\": {\"left-pad\": \"1.0.0\", \"is-odd\": \"3.0.1\"}}\n// ///\n")
+	script := e.script(t, "s.ts", "// /// script\n// {\"env\": {\"FAKE_ENV_B\": \"script\"}, \"dependencies\": {\"left-pad\": \"1.3.0\"}}\n// ///\n")
+
+	out, code := e.run(t, "", nil, "run", "--merge-env-from", base, script)
+	if code != 0 || !strings.Contains(out, "FAKE_ENV_A=base\nFAKE_ENV_B=script\n") {
+		t.Fatalf("run exited with %d, want the base's env under the script's:\n%s", code, out)
+	}
+	versions := map[string]string{}
+	for _, name := range []string{"left-pad", "is-odd"} {
+		matches, _ := filepath.Glob(filepath.Join(e.cacheDir, "*", "node_modules", name, "package.json"))
+		if len(matches) != 1 {
+			t.Fatalf("%s installed %d times", name, len(matches))
+		}
+		data, err := os.ReadFile(matches[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		versions[name] = string(data)
+	}
+	if !strings.Contains(versions["left-pad"], `"1.3.0"`) || !strings.Contains(versions["is-odd"], `"3.0.1"`) {
+		t.Errorf("installed %v, want left-pad from the script and is-odd from the base", versions)
+	}
+
+	out, code = e.run(t, "", nil, "run", "--merge-env-from", script, script)
+	if code != 1 || !strings.Contains(out, "cannot merge from itself") {
+		t.Errorf("merging a script into itself exited with %d:\n%s", code, out)
+	}
+}
+
+func TestWithConflictWarning(t *testing.T) {
+	e := newBunvEnv(t)
+	script := e.script(t, "s.ts", leftPadScript)
+	nodePath := regexp.MustCompile(`NODE_PATH=([^:\n]+)`)
+	installed := func(out string) string {
+		t.Helper()
+		m := nodePath.FindStringSubmatch(out)
+		if m == nil {
+			t.Fatalf("no NODE_PATH in output:\n%s", out)
+		}
+		data, err := os.ReadFile(filepath.Join(m[1], "node_modules", "left-pad", "package.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return regexp.MustCompile(`"version": "([^"]*)"`).FindStringSubmatch(string(data))[1]
+	}
+	tests := []struct {
+		args        []string
+		warning     string
+		wantVersion string
+	}{
+		{[]string{"--with", "left-pad@1.3.0"}, "", "1.3.0"},
+		{[]string{"--with", "left-pad@1.1.0"}, "Warning: --with left-pad@1.1.0 conflicts with left-pad@1.3.0 in " + script + "; using 1.3.0 from the header (pass --with-override to use --with)", "1.3.0"},
+		{[]string{"--with", "left-pad@1.1.0", "--with-override"}, "Warning: --with left-pad@1.1.0 conflicts with left-pad@1.3.0 in " + script + "; using 1.1.0 from --with", "1.1.0"},
+	}
+	for _, tt := range tests {
+		out, code := e.run(t, "", nil, append(append([]string{"run"}, tt.args...), script)...)
+		if code != 0 {
+			t.Fatalf("%q exited with %d:\n%s", tt.args, code, out)
+		}
+		if tt.warning == "" && strings.Contains(out, "conflicts with") {
+			t.Errorf("%q warned about a matching version:\n%s", tt.args, out)
+		} else if !strings.Contains(out, tt.warning) {
+			t.Errorf("%q output is missing %q:\n%s", tt.args, tt.warning, out)
+		}
+		if got := installed(out); got != tt.wantVersion {
+			t.Errorf("%q installed left-pad %s, want %s", tt.args, got, tt.wantVersion)
+		}
+	}
+}
+
+func TestInstallOutputTruncated(t *testing.T) {
+	e := newBunvEnv(t)
+	script := e.script(t, "s.ts", leftPadScript)
+	env := []string{"FAKE_BUN_OUTPUT_LINES=50"}
+
+	out, code := e.run(t, "", env, "run", "--max-install-output-lines", "10", script)
+	if code != 0 {
+		t.Fatalf("run exited with %d:\n%s", code, out)
+	}
+	if !strings.Contains(out, "install line 9\n... (40 lines omitted)\n") || strings.Contains(out, "install line 10\n") {
+		t.Errorf("install output not cut to 10 lines:\n%s", out)
+	}
+
+	// A failed install shows everything
+	out, code = e.run(t, "", append(env, "FAKE_BUN_FAILURES=1"), "run", "--refresh", "--max-install-output-lines", "10", script)
+	if code != 1 {
+		t.Fatalf("run exited with %d, want a failed install:\n%s", code, out)
+	}
+	if !strings.Contains(out, "Full install output:\n") || strings.Contains(out, "lines omitted") {
+		t.Errorf("failed install didn't print its full output:\n%s", out)
+	}
+}
+
+func TestPrintInstallPlanWithoutTerminal(t *testing.T) {
+	e := newBunvEnv(t)
+	script := e.script(t, "s.ts", leftPadScript)
+
+	// Piped stdin isn't a terminal, so the install goes ahead unasked
+	out, code := e.run(t, "n\n", nil, "run", "--print-install-plan", script)
+	if code != 0 || !strings.Contains(out, "RUN ") {
+		t.Fatalf("run exited with %d:\n%s", code, out)
+	}
+	if !strings.Contains(out, "Install plan:\n  @types/node@latest\n  left-pad@1.3.0\n") || !strings.Contains(out, "Cache: cold (new install)\nCommand: bun install\n") {
+		t.Errorf("plan missing from output:\n%s", out)
+	}
+	if strings.Contains(out, "Proceed with install?") {
+		t.Errorf("asked for confirmation without a terminal:\n%s", out)
+	}
+
+	out, code = e.run(t, "", nil, "run", "--print-install-plan", script)
+	if code != 0 || !strings.Contains(out, "Cache: warm (reusing existing install)\n") {
+		t.Errorf("second run exited with %d, want a warm plan:\n%s", code, out)
+	}
+}
+
+func TestDeterministicRejectsLooseVersions(t *testing.T) {
+	e := newBunvEnv(t)
+	loose := e.script(t, "loose.ts", "// /// script\n// {\"dependencies\": {\"left-pad\": \"^1.3.0\"}}\n// ///\n")
+	out, code := e.run(t, "", nil, "run", "--deterministic", "--no-types", loose)
+	if code != 1 || !strings.Contains(out, "loose versions: left-pad@^1.3.0") {
+		t.Errorf("loose script exited with %d:\n%s", code, out)
+	}
+	if slices.ContainsFunc(e.calls(t), func(call string) bool { return strings.HasPrefix(call, "install") }) {
+		t.Error("bun install ran for a rejected script")
+	}
+
+	exact := e.script(t, "exact.ts", leftPadScript)
+	if out, code := e.run(t, "", nil, "run", "--deterministic", "--no-types", exact); code != 0 || !strings.Contains(out, "RUN ") {
+		t.Errorf("pinned script exited with %d:\n%s", code, out)
+	}
+}
+
+func TestEnvFilePrecedence(t *testing.T) {
+	e := newBunvEnv(t)
+	script := e.script(t, "s.ts", "// /// script\n// {\"env\": {\"FAKE_ENV_A\": \"header\", \"FAKE_ENV_D\": \"header\"}}\n// ///\n")
+	first := e.script(t, "first.env", "FAKE_ENV_A=first\nFAKE_ENV_B=first\nFAKE_ENV_C=first\nnot a variable\n")
+	second := e.script(t, "second.env", "FAKE_ENV_B=second\n")
+	inherited := []string{"FAKE_ENV_C=inherited", "FAKE_ENV_E=inherited"}
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{nil, "FAKE_ENV_A=header\nFAKE_ENV_C=inherited\nFAKE_ENV_D=header\nFAKE_ENV_E=inherited\n"},
+		// Files beat the header and the inherited environment, and later files win
+		{[]string{"--env-file", first, "--env-file", second}, "FAKE_ENV_A=first\nFAKE_ENV_B=second\nFAKE_ENV_C=first\nFAKE_ENV_D=header\nFAKE_ENV_E=inherited\n"},
+		{[]string{"--env-file", second, "--env-file", first}, "FAKE_ENV_A=first\nFAKE_ENV_B=first\nFAKE_ENV_C=first\nFAKE_ENV_D=header\nFAKE_ENV_E=inherited\n"},
+		// --env-override lets the inherited environment win over the files
+		{[]string{"--env-file", first, "--env-override"}, "FAKE_ENV_A=first\nFAKE_ENV_B=first\nFAKE_ENV_C=inherited\nFAKE_ENV_D=header\nFAKE_ENV_E=inherited\n"},
+	}
+	for _, tt := range tests {
+		out, code := e.run(t, "", inherited, append(append([]string{"run"}, tt.args...), script)...)
+		if code != 0 || !strings.Contains(out, tt.want) {
+			t.Errorf("%q exited with %d:\n%s\nwant the environment:\n%s", tt.args, code, out, tt.want)
+		}
+	}
+
+	out, _ := e.run(t, "", nil, "run", "--env-file", first, script)
+	if !strings.Contains(out, "Warning: "+first+":4: ignoring malformed line") {
+		t.Errorf("no warning for the malformed line:\n%s", out)
+	}
+	out, code := e.run(t, "", nil, "run", "--env-file", filepath.Join(e.dir, "missing.env"), script)
+	if code != 1 || !strings.Contains(out, "Error reading env file") {
+		t.Errorf("missing env file exited with %d:\n%s", code, out)
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe to write from a child's output while a test reads it.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestWatchRestartsOnChange(t *testing.T) {
+	e := newBunvEnv(t)
+	script := e.script(t, "s.ts", leftPadScript)
+	cmd := exec.Command(os.Args[0], "run", "--watch", script)
+	cmd.Dir = e.dir
+	cmd.Env = e.env
+	var out syncBuffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+	waitFor := func(what string, done func(string) bool) {
+		t.Helper()
+		for deadline := time.Now().Add(10 * time.Second); !done(out.String()); time.Sleep(20 * time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s:\n%s", what, out.String())
+			}
+		}
+	}
+
+	waitFor("the first run", func(s string) bool { return strings.Contains(s, "waiting for changes") })
+	if err := os.WriteFile(script, []byte(leftPadScript+"console.log(2)\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("a re-run", func(s string) bool { return strings.Count(s, "RUN ") == 2 })
+	if !strings.Contains(out.String(), "s.ts changed, re-running") {
+		t.Errorf("restart not announced:\n%s", out.String())
+	}
+
+	cmd.Process.Signal(syscall.SIGTERM)
+	if err := cmd.Wait(); err != nil {
+		t.Errorf("--watch didn't exit cleanly on SIGTERM: %v\n%s", err, out.String())
+	}
+}
+
+func TestExecOneLiner(t *testing.T) {
+	e := newBunvEnv(t)
+
+	out, code := e.run(t, "", []string{"FAKE_BUN_EXIT=3"}, "exec", "--with", "left-pad@1.3.0", `console.log(require("left-pad")("x", 3))`, "--", "arg")
+	if code != 3 {
+		t.Errorf("exec exited with %d, want the script's 3:\n%s", code, out)
+	}
+	if !regexp.MustCompile(`RUN \S+/\.exec-\d+\.ts arg\n`).MatchString(out) {
+		t.Errorf("snippet not run with its arguments:\n%s", out)
+	}
+	if !strings.Contains(out, `SCRIPT: console.log(require("left-pad")("x", 3))`) {
+		t.Errorf("snippet not written to the script:\n%s", out)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(e.cacheDir, "*", "node_modules", "left-pad")); len(matches) != 1 {
+		t.Errorf("--with package not installed: %q", matches)
+	}
+	left, _ := filepath.Glob(filepath.Join(e.cacheDir, ".exec-*"))
+	links, _ := filepath.Glob(filepath.Join(e.cacheDir, "*", ".exec-*"))
+	if len(left)+len(links) > 0 {
+		t.Errorf("snippet left behind: %q", append(left, links...))
+	}
+
+	out, code = e.run(t, "", nil, "exec", "--code", "console.log(1)")
+	if code != 0 || !strings.Contains(out, "SCRIPT: console.log(1)") {
+		t.Errorf("exec --code exited with %d:\n%s", code, out)
+	}
+}
+
+func TestGitSpecs(t *testing.T) {
+	e := newBunvEnv(t)
+	script := e.script(t, "s.ts", "console.log(1)\n")
+	nodePath := regexp.MustCompile(`NODE_PATH=([^:\n]+)`)
+	cacheDirs := map[string]bool{}
+	for _, spec := range []string{"github:owner/Repo#v1", "github:owner/Repo#v2", "git+https://github.com/owner/repo.git#main"} {
+		out, code := e.run(t, "", nil, "run", "--no-types", "--with", spec, script)
+		m := nodePath.FindStringSubmatch(out)
+		if code != 0 || m == nil {
+			t.Fatalf("--with %s exited with %d:\n%s", spec, code, out)
+		}
+		cacheDirs[m[1]] = true
+		data, err := os.ReadFile(filepath.Join(m[1], "package.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := `"repo": "` + spec + `"`; !strings.Contains(string(data), want) {
+			t.Errorf("--with %s: package.json doesn't contain %s:\n%s", spec, want, data)
+		}
+	}
+	if len(cacheDirs) != 3 {
+		t.Errorf("three git specs shared cache directories: %v", cacheDirs)
+	}
+}
+
+func TestAddFromManifest(t *testing.T) {
+	e := newBunvEnv(t)
+	script := e.script(t, "s.ts", "console.log(1)\n")
+	manifest := e.script(t, "package.json", `{"dependencies": {"react": "^18.2.0"}, "devDependencies": {"zod": "~3.22.0"}}`)
+	out, code := e.run(t, "", nil, "add", "--script", script, "--from", manifest, "react", "zod", "chalk@5", "missing")
+	if code != 0 {
+		t.Fatalf("add --from exited with %d:\n%s", code, out)
+	}
+	if !strings.Contains(out, "Warning: missing not found in manifest, using latest") {
+		t.Errorf("no warning for a package missing from the manifest:\n%s", out)
+	}
+	if strings.Contains(out, "chalk not found") {
+		t.Errorf("warned about a package given a version:\n%s", out)
+	}
+
+	data, err := os.ReadFile(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, _, _, _ := parseMetadataBlock(string(data))
+	want := Dependencies{"react": "^18.2.0", "zod": "~3.22.0", "chalk": "5", "missing": "latest"}
+	if got := Dependencies(headerStringMap(header, "dependencies")); !maps.Equal(got, want) {
+		t.Errorf("dependencies = %v, want %v", got, want)
+	}
+}
+
+func TestBlockedPackageRejectedBeforeInstall(t *testing.T) {
+	e := newBunvEnv(t)
+	blocklist := e.script(t, "blocklist", "# known bad\nevent-stream\n@evil/*\n")
+	tests := []struct {
+		with, pattern string
+		env           bool
+	}{
+		{"event-stream@3.3.6", "event-stream", false},
+		{"@evil/pkg@1.0.0", "@evil/*", false},
+		{"@evil/pkg@1.0.0", "@evil/*", true},
+	}
+	for _, tt := range tests {
+		script := e.script(t, "s.ts", "// /// script\n// {\"dependencies\": {\"left-pad\": \"1.3.0\"}}\n// ///\n")
+		args := []string{"run", "--no-types", "--with", tt.with}
+		var env []string
+		if tt.env {
+			env = []string{"BUNV_DEPENDENCY_BLOCKLIST=" + blocklist}
+		} else {
+			args = append(args, "--dependency-blocklist", blocklist)
+		}
+		out, code := e.run(t, "", env, append(args, script)...)
+		name, _ := parsePackageSpec(tt.with)
+		if want := fmt.Sprintf("Error: %s is blocked by %q", name, tt.pattern); code == 0 || !strings.Contains(out, want) {
+			t.Errorf("--with %s (env %v) exited with %d, want %q:\n%s", tt.with, tt.env, code, want, out)
+		}
+		if slices.ContainsFunc(e.calls(t), func(call string) bool { return strings.HasPrefix(call, "install") }) {
+			t.Errorf("--with %s (env %v): bun install ran for a blocked package", tt.with, tt.env)
+		}
+	}
+
+	script := e.script(t, "s.ts", "console.log(1)\n")
+	if out, code := e.run(t, "", nil, "run", "--no-types", "--with", "left-pad@1.3.0", "--dependency-blocklist", blocklist, script); code != 0 {
+		t.Errorf("an unblocked package exited with %d:\n%s", code, out)
+	}
+	if !slices.ContainsFunc(e.calls(t), func(call string) bool { return strings.HasPrefix(call, "install") }) {
+		t.Error("bun install didn't run for an unblocked package")
+	}
+}
+
+func TestFrozenSavesAndRestoresLockfile(t *testing.T) {
+	e := newBunvEnv(t)
+	script := e.script(t, "s.ts", "// /// script\n// {\"dependencies\": {\"left-pad\": \"^1.3.0\"}}\n// ///\n")
+	saved := script + ".lock"
+
+	out, code := e.run(t, "", []string{"FAKE_BUN_LOCKFILE=resolved first"}, "run", "--no-types", "--frozen", script)
+	if code != 0 || !strings.Contains(out, "Saved lockfile to "+saved) {
+		t.Fatalf("first --frozen run exited with %d:\n%s", code, out)
+	}
+	if data, err := os.ReadFile(saved); err != nil || string(data) != "resolved first\n" {
+		t.Fatalf("saved lockfile = %q, %v", data, err)
+	}
+
+	// A fresh cache installs from the saved lockfile, not whatever the
+	// registry would resolve now
+	if err := os.RemoveAll(e.cacheDir); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(filepath.Join(filepath.Dir(e.bunPath), "calls.txt"))
+	out, code = e.run(t, "", []string{"FAKE_BUN_LOCKFILE=resolved later"}, "run", "--no-types", "--frozen", script)
+	if code != 0 {
+		t.Fatalf("second --frozen run exited with %d:\n%s", code, out)
+	}
+	installs := slices.DeleteFunc(e.calls(t), func(call string) bool { return !strings.HasPrefix(call, "install") })
+	if len(installs) != 1 || !strings.Contains(installs[0], "--frozen-lockfile") {
+		t.Errorf("installs = %q, want one with --frozen-lockfile", installs)
+	}
+	locks, _ := filepath.Glob(filepath.Join(e.cacheDir, "*", "bun.lock"))
+	if len(locks) != 1 {
+		t.Fatalf("cache lockfiles = %q", locks)
+	}
+	if data, _ := os.ReadFile(locks[0]); string(data) != "resolved first\n" {
+		t.Errorf("cache lockfile = %q, want the saved one", data)
+	}
+	if data, _ := os.ReadFile(saved); string(data) != "resolved first\n" {
+		t.Errorf("saved lockfile was overwritten with %q", data)
+	}
+}
+
+func TestOfflineNeverInstallsOrFetches(t *testing.T) {
+	e := newBunvEnv(t)
+	script := e.script(t, "s.ts", "// /// script\n// {\"dependencies\": {\"left-pad\": \"^1.3.0\", \"zod\": \"3.23.8\"}}\n// ///\n")
+	installed := func() bool {
+		return slices.ContainsFunc(e.calls(t), func(call string) bool { return strings.HasPrefix(call, "install") })
+	}
+
+	out, code := e.run(t, "", nil, "run", "--no-types", "--offline", script)
+	if code == 0 || !strings.Contains(out, "they are missing: left-pad, zod") {
+		t.Errorf("--offline with an empty cache exited with %d:\n%s", code, out)
+	}
+	if installed() {
+		t.Error("--offline ran bun install")
+	}
+
+	if out, code := e.run(t, "", nil, "run", "--no-types", script); code != 0 {
+		t.Fatalf("populating the cache exited with %d:\n%s", code, out)
+	}
+	os.Remove(filepath.Join(filepath.Dir(e.bunPath), "calls.txt"))
+	out, code = e.run(t, "", nil, "run", "--no-types", "--offline", script)
+	if code != 0 || !strings.Contains(out, "RUN ") {
+		t.Errorf("--offline with a populated cache exited with %d:\n%s", code, out)
+	}
+	if installed() {
+		t.Error("--offline ran bun install with a populated cache")
+	}
+
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Write([]byte("console.log(1)\n"))
+	}))
+	defer srv.Close()
+	out, code = e.run(t, "", nil, "run", "--no-types", "--offline", srv.URL+"/s.ts")
+	if code == 0 || !strings.Contains(out, "Can't fetch") {
+		t.Errorf("--offline with a remote script exited with %d:\n%s", code, out)
+	}
+	if n := requests.Load(); n != 0 {
+		t.Errorf("--offline made %d requests for a remote script", n)
+	}
+}
+
+func TestMalformedMetadata(t *testing.T) {
+	e := newBunvEnv(t)
+	script := e.script(t, "s.ts", "// /// script\n// {\n//   \"dependencies\": {\"left-pad\": \"1.3.0\",}\n// }\n// ///\nconsole.log(1)\n")
+
+	out, code := e.run(t, "", nil, "run", "--no-types", script)
+	if code != 0 || !strings.Contains(out, "Warning: Ignoring malformed metadata block in "+script+": line 3:") {
+		t.Errorf("malformed block exited with %d, want a warning naming line 3:\n%s", code, out)
+	}
+	if !strings.Contains(out, "SCRIPT: console.log(1)") {
+		t.Errorf("script didn't run after the warning:\n%s", out)
+	}
+
+	os.Remove(filepath.Join(filepath.Dir(e.bunPath), "calls.txt"))
+	out, code = e.run(t, "", nil, "run", "--no-types", "--strict", script)
+	if code == 0 || !strings.Contains(out, "Error: Malformed metadata block in "+script+": line 3:") {
+		t.Errorf("--strict exited with %d, want an error naming line 3:\n%s", code, out)
+	}
+	if slices.ContainsFunc(e.calls(t), func(call string) bool { return strings.HasPrefix(call, "run") }) {
+		t.Error("--strict ran the script")
+	}
+}
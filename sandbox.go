@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// A sandbox wraps the bun invocation in a platform isolation tool.
+type sandbox interface {
+	Name() string
+	Available() bool
+	// Wrap returns the executable and argv (including argv[0]) that run
+	// bunPath with bunArgs inside the sandbox, in runDir. The script can
+	// read runDir but only write to scratchDir, a private directory that
+	// serves as its HOME and TMPDIR, so it can't tamper with a cache entry
+	// that later runs reuse. readOnly lists further paths the script may
+	// read even under a hidden home, such as its own directory.
+	Wrap(bunPath string, bunArgs []string, runDir, scratchDir string, readOnly []string) (string, []string)
+}
+
+var sandboxes = []sandbox{bwrapSandbox{}, sandboxExecSandbox{}}
+
+// findSandbox returns the first sandbox usable on this platform, or nil.
+func findSandbox() sandbox {
+	for _, sb := range sandboxes {
+		if sb.Available() {
+			return sb
+		}
+	}
+	return nil
+}
+
+// bwrapSandbox uses bubblewrap on Linux.
+type bwrapSandbox struct{}
+
+func (bwrapSandbox) Name() string { return "bwrap" }
+
+func (bwrapSandbox) Available() bool {
+	if runtime.GOOS != "linux" {
+		return false
+	}
+	_, err := exec.LookPath("bwrap")
+	return err == nil
+}
+
+func (bwrapSandbox) Wrap(bunPath string, bunArgs []string, runDir, scratchDir string, readOnly []string) (string, []string) {
+	path, _ := exec.LookPath("bwrap")
+	bunPath = realPath(bunPath)
+	argv := []string{
+		path,
+		"--ro-bind", "/", "/",
+		"--dev", "/dev",
+		"--proc", "/proc",
+		"--tmpfs", "/tmp",
+	}
+	// Later mounts win, so runDir, bun and readOnly show through the
+	// hidden home
+	if home := sandboxHiddenHome(); home != "" {
+		argv = append(argv, "--tmpfs", home)
+	}
+	for _, path := range readOnly {
+		path = realPath(path)
+		argv = append(argv, "--ro-bind-try", path, path)
+	}
+	argv = append(argv,
+		"--ro-bind", runDir, runDir,
+		"--ro-bind", bunPath, bunPath,
+		"--bind", scratchDir, scratchDir,
+		"--unshare-all",
+		"--die-with-parent",
+		"--chdir", runDir,
+		"--", bunPath,
+	)
+	return path, append(argv, bunArgs...)
+}
+
+// sandboxHiddenHome returns the home directory sandboxes hide, or "" if there is none.
+func sandboxHiddenHome() string {
+	home, err := os.UserHomeDir()
+	if err != nil || home == "/" {
+		return ""
+	}
+	return realPath(home)
+}
+
+// realPath returns path with symlinks resolved, or path itself if that fails.
+func realPath(path string) string {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		return resolved
+	}
+	return path
+}
+
+// sandboxExecSandbox uses sandbox-exec on macOS.
+type sandboxExecSandbox struct{}
+
+func (sandboxExecSandbox) Name() string { return "sandbox-exec" }
+
+func (sandboxExecSandbox) Available() bool {
+	if runtime.GOOS != "darwin" {
+		return false
+	}
+	_, err := exec.LookPath("sandbox-exec")
+	return err == nil
+}
+
+func (sandboxExecSandbox) Wrap(bunPath string, bunArgs []string, runDir, scratchDir string, readOnly []string) (string, []string) {
+	path, _ := exec.LookPath("sandbox-exec")
+	bunPath = realPath(bunPath)
+	profile := fmt.Sprintf(`(version 1)
+(allow default)
+(deny network*)
+(deny file-write*)
+(allow file-write* (subpath %q) (subpath "/dev"))`, realPath(scratchDir))
+	if home := sandboxHiddenHome(); home != "" {
+		profile += fmt.Sprintf(`
+(deny file-read* (subpath %q))
+(allow file-read* (subpath %q) (subpath %q) (literal %q))`, home, realPath(runDir), realPath(scratchDir), bunPath)
+		for _, path := range readOnly {
+			profile += fmt.Sprintf(`
+(allow file-read* (subpath %q))`, realPath(path))
+		}
+	}
+	argv := append([]string{path, "-p", profile, bunPath}, bunArgs...)
+	return path, argv
+}
+
+// sandboxEnvAllowlist names the variables passed through to a sandboxed script.
+var sandboxEnvAllowlist = []string{"PATH", "LANG", "LC_", "TERM", "TZ", "NODE_PATH"}
+
+// sandboxEnv keeps the allowlisted variables and points HOME and TMPDIR at scratchDir.
+func sandboxEnv(env []string, scratchDir string) []string {
+	out := []string{}
+	for _, kv := range env {
+		name, _, _ := strings.Cut(kv, "=")
+		for _, allowed := range sandboxEnvAllowlist {
+			if name == allowed || (strings.HasSuffix(allowed, "_") && strings.HasPrefix(name, allowed)) {
+				out = append(out, kv)
+				break
+			}
+		}
+	}
+	return append(out, "HOME="+scratchDir, "TMPDIR="+scratchDir)
+}
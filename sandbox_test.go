@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSandboxEnv(t *testing.T) {
+	env := sandboxEnv([]string{
+		"PATH=/usr/bin",
+		"LC_ALL=C",
+		"AWS_SECRET_ACCESS_KEY=secret",
+		"HOME=/home/user",
+		"PATHOLOGICAL=1",
+	}, "/tmp/scratch")
+	want := []string{"PATH=/usr/bin", "LC_ALL=C", "HOME=/tmp/scratch", "TMPDIR=/tmp/scratch"}
+	if !slices.Equal(env, want) {
+		t.Errorf("sandboxEnv = %q, want %q", env, want)
+	}
+}
+
+// indexOfMount returns the index of flag followed by args in argv, or -1.
+func indexOfMount(argv []string, flag string, args ...string) int {
+	for i := range argv {
+		if argv[i] == flag && i+len(args) < len(argv) && slices.Equal(argv[i+1:i+1+len(args)], args) {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestBwrapWrap(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	home = realPath(home)
+	runDir := filepath.Join(home, ".cache", "bunv", "abc")
+	bunPath := filepath.Join(home, ".bun", "bin", "bun")
+	scratch := filepath.Join(t.TempDir(), "scratch")
+	scriptDir := filepath.Join(home, "scripts")
+
+	_, argv := bwrapSandbox{}.Wrap(bunPath, []string{"run", "script.ts"}, runDir, scratch, []string{scriptDir})
+
+	hidden := indexOfMount(argv, "--tmpfs", home)
+	if hidden < 0 {
+		t.Fatalf("home directory not hidden: %q", argv)
+	}
+	for _, mount := range [][]string{
+		{"--ro-bind", runDir, runDir},
+		{"--ro-bind", bunPath, bunPath},
+		{"--bind", scratch, scratch},
+		{"--ro-bind-try", scriptDir, scriptDir},
+	} {
+		if i := indexOfMount(argv, mount[0], mount[1:]...); i < hidden {
+			t.Errorf("%q missing or before the home tmpfs: %q", mount, argv)
+		}
+	}
+	if indexOfMount(argv, "--bind", runDir, runDir) >= 0 || indexOfMount(argv, "--bind", scriptDir, scriptDir) >= 0 {
+		t.Errorf("run or script directory is writable: %q", argv)
+	}
+	if tail := argv[len(argv)-4:]; !slices.Equal(tail, []string{"--", bunPath, "run", "script.ts"}) {
+		t.Errorf("argv ends with %q", tail)
+	}
+}
+
+func TestSandboxExecWrap(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	home = realPath(home)
+	scriptDir := filepath.Join(home, "scripts")
+
+	_, argv := sandboxExecSandbox{}.Wrap("/usr/local/bin/bun", []string{"run", "script.ts"}, filepath.Join(home, "cache"), filepath.Join(home, "scratch"), []string{scriptDir})
+
+	profile := argv[2]
+	for _, rule := range []string{
+		fmt.Sprintf("(deny file-read* (subpath %q))", home),
+		fmt.Sprintf("(allow file-read* (subpath %q))", scriptDir),
+	} {
+		if !strings.Contains(profile, rule) {
+			t.Errorf("profile is missing %s:\n%s", rule, profile)
+		}
+	}
+	if strings.Contains(profile, fmt.Sprintf("(allow file-write* (subpath %q)", scriptDir)) {
+		t.Errorf("script directory is writable:\n%s", profile)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+const sbomGolden = `{
+  "bomFormat": "CycloneDX",
+  "specVersion": "1.5",
+  "serialNumber": "urn:uuid:SERIAL",
+  "version": 1,
+  "metadata": {
+    "timestamp": "TIMESTAMP",
+    "tools": [
+      {
+        "name": "bunv"
+      }
+    ],
+    "component": {
+      "type": "application",
+      "name": "script.ts"
+    }
+  },
+  "components": [
+    {
+      "type": "library",
+      "name": "@scope/pkg",
+      "version": "2.0.0",
+      "purl": "pkg:npm/%40scope/pkg@2.0.0",
+      "licenses": [
+        {
+          "license": {
+            "name": "Apache-2.0"
+          }
+        }
+      ]
+    },
+    {
+      "type": "library",
+      "name": "left-pad",
+      "version": "1.3.0",
+      "purl": "pkg:npm/left-pad@1.3.0",
+      "licenses": [
+        {
+          "license": {
+            "name": "MIT"
+          }
+        }
+      ]
+    },
+    {
+      "type": "library",
+      "name": "nested",
+      "version": "0.1.0",
+      "purl": "pkg:npm/nested@0.1.0"
+    }
+  ]
+}
+`
+
+func TestWriteSBOMGolden(t *testing.T) {
+	cacheDir := t.TempDir()
+	manifests := map[string]string{
+		"left-pad/package.json":                         `{"name": "left-pad", "version": "1.3.0", "license": "MIT"}`,
+		"@scope/pkg/package.json":                       `{"name": "@scope/pkg", "version": "2.0.0", "license": {"type": "Apache-2.0"}}`,
+		"@scope/pkg/node_modules/nested/package.json":   `{"name": "nested", "version": "0.1.0"}`,
+		"left-pad/node_modules/nested/package.json":     `{"name": "nested", "version": "0.1.0"}`,
+		"left-pad/lib/package.json":                     `{"name": "not-a-package", "version": "9.9.9"}`,
+		".bin/package.json":                             `{"name": "bin", "version": "1.0.0"}`,
+		"@scope/pkg/node_modules/.cache/x/package.json": `{"name": "cached", "version": "1.0.0"}`,
+	}
+	for name, content := range manifests {
+		path := filepath.Join(cacheDir, "node_modules", filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	out := filepath.Join(t.TempDir(), "sbom.json")
+	if err := writeSBOM(out, "/scripts/script.ts", cacheDir); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The serial number and timestamp differ on every run
+	got := regexp.MustCompile(`urn:uuid:[0-9a-f-]{36}`).ReplaceAllString(string(data), "urn:uuid:SERIAL")
+	got = regexp.MustCompile(`"timestamp": "\d{4}-\d\d-\d\dT\d\d:\d\d:\d\dZ"`).ReplaceAllString(got, `"timestamp": "TIMESTAMP"`)
+	if got != sbomGolden {
+		t.Errorf("SBOM:\n%s\nwant:\n%s", got, sbomGolden)
+	}
+}
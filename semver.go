@@ -0,0 +1,267 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// parseVersion parses a version like "1", "1.1" or "v1.1.30-canary+abc".
+func parseVersion(v string) ([3]int, error) {
+	var parts [3]int
+	core := strings.TrimPrefix(strings.TrimSpace(v), "v")
+	if i := strings.IndexAny(core, "-+"); i >= 0 {
+		core = core[:i]
+	}
+	fields := strings.Split(core, ".")
+	if core == "" || len(fields) > 3 {
+		return parts, fmt.Errorf("invalid version %q", v)
+	}
+	for i, f := range fields {
+		n, err := strconv.Atoi(f)
+		if err != nil || n < 0 {
+			return parts, fmt.Errorf("invalid version %q", v)
+		}
+		parts[i] = n
+	}
+	return parts, nil
+}
+
+// compareVersions returns -1, 0 or 1 as a is older than, equal to or newer than b.
+func compareVersions(a, b [3]int) int {
+	for i := range a {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// A comparator is a single version constraint such as ">=1.2.0".
+type comparator struct {
+	op string
+	v  [3]int
+}
+
+func (c comparator) matches(v [3]int) bool {
+	cmp := compareVersions(v, c.v)
+	switch c.op {
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	default:
+		return cmp == 0
+	}
+}
+
+// A versionRange is a set of alternatives, each a set of comparators that must all match.
+type versionRange [][]comparator
+
+// Matches reports whether v satisfies the range.
+func (r versionRange) Matches(v [3]int) bool {
+	for _, set := range r {
+		ok := true
+		for _, c := range set {
+			if !c.matches(v) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePartial parses a possibly partial version like "1.2" or "1.x".
+func parsePartial(v string) ([3]int, int, error) {
+	var parts [3]int
+	core := strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(v), "="), "v")
+	if i := strings.IndexAny(core, "-+"); i >= 0 {
+		core = core[:i]
+	}
+	if core == "" || core == "*" || core == "x" || core == "X" {
+		return parts, 0, nil
+	}
+	fields := strings.Split(core, ".")
+	if len(fields) > 3 {
+		return parts, 0, fmt.Errorf("invalid version %q", v)
+	}
+	n := 0
+	for i, f := range fields {
+		if f == "x" || f == "X" || f == "*" {
+			break
+		}
+		num, err := strconv.Atoi(f)
+		if err != nil || num < 0 {
+			return parts, 0, fmt.Errorf("invalid version %q", v)
+		}
+		parts[i] = num
+		n++
+	}
+	return parts, n, nil
+}
+
+// bumpPartial returns the smallest version above every match of the first n parts of v.
+func bumpPartial(v [3]int, n int) [3]int {
+	var next [3]int
+	copy(next[:], v[:n])
+	next[n-1]++
+	return next
+}
+
+// xRange returns the comparators for a partial version.
+func xRange(v [3]int, n int) []comparator {
+	if n == 0 {
+		return nil
+	}
+	if n == 3 {
+		return []comparator{{"=", v}}
+	}
+	return []comparator{{">=", v}, {"<", bumpPartial(v, n)}}
+}
+
+// parseRange parses an npm-style version range.
+func parseRange(spec string) (versionRange, error) {
+	var r versionRange
+	for _, alt := range strings.Split(spec, "||") {
+		alt = strings.TrimSpace(alt)
+		if lo, hi, ok := strings.Cut(alt, " - "); ok {
+			from, _, err := parsePartial(lo)
+			if err != nil {
+				return nil, err
+			}
+			to, tn, err := parsePartial(hi)
+			if err != nil {
+				return nil, err
+			}
+			set := []comparator{{">=", from}}
+			if tn == 3 {
+				set = append(set, comparator{"<=", to})
+			} else if tn > 0 {
+				set = append(set, comparator{"<", bumpPartial(to, tn)})
+			}
+			r = append(r, set)
+			continue
+		}
+
+		set := []comparator{}
+		// Allow a space between an operator and its version, as npm does
+		fields := strings.Fields(alt)
+		for i := 0; i < len(fields); i++ {
+			tok := fields[i]
+			if strings.Trim(tok, "<>=~^") == "" && i+1 < len(fields) {
+				tok += fields[i+1]
+				i++
+			}
+			cs, err := parseComparator(tok)
+			if err != nil {
+				return nil, err
+			}
+			set = append(set, cs...)
+		}
+		r = append(r, set)
+	}
+	return r, nil
+}
+
+func parseComparator(tok string) ([]comparator, error) {
+	switch {
+	case strings.HasPrefix(tok, "^"):
+		v, n, err := parsePartial(tok[1:])
+		if err != nil || n == 0 {
+			return nil, err
+		}
+		// The upper bound bumps the first non-zero part that was given
+		bumpAt := 1
+		for bumpAt < n && v[bumpAt-1] == 0 {
+			bumpAt++
+		}
+		return []comparator{{">=", v}, {"<", bumpPartial(v, bumpAt)}}, nil
+	case strings.HasPrefix(tok, "~"):
+		v, n, err := parsePartial(strings.TrimPrefix(tok[1:], ">"))
+		if err != nil || n == 0 {
+			return nil, err
+		}
+		if n == 1 {
+			return []comparator{{">=", v}, {"<", bumpPartial(v, 1)}}, nil
+		}
+		return []comparator{{">=", v}, {"<", bumpPartial(v, 2)}}, nil
+	case strings.HasPrefix(tok, ">="), strings.HasPrefix(tok, "<="):
+		v, n, err := parsePartial(tok[2:])
+		if err != nil || n == 0 {
+			return nil, err
+		}
+		if tok[0] == '<' && n < 3 {
+			return []comparator{{"<", bumpPartial(v, n)}}, nil
+		}
+		return []comparator{{tok[:2], v}}, nil
+	case strings.HasPrefix(tok, ">"), strings.HasPrefix(tok, "<"):
+		v, n, err := parsePartial(tok[1:])
+		if err != nil || n == 0 {
+			return nil, err
+		}
+		if tok[0] == '>' && n < 3 {
+			return []comparator{{">=", bumpPartial(v, n)}}, nil
+		}
+		return []comparator{{tok[:1], v}}, nil
+	default:
+		v, n, err := parsePartial(tok)
+		if err != nil {
+			return nil, err
+		}
+		return xRange(v, n), nil
+	}
+}
+
+// distTagRe matches npm dist-tags such as "latest", "next" or "beta".
+var distTagRe = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9._-]*$`)
+
+// repoShorthandRe matches GitHub shorthand such as "user/repo#v1".
+var repoShorthandRe = regexp.MustCompile(`^[\w.-]+/[\w.-]+(#.*)?$`)
+
+// Spec prefixes accepted in place of a version, which bunv doesn't check.
+var versionProtocols = []string{"npm:", "file:", "link:", "workspace:", "git+", "git:", "github:", "gitlab:", "bitbucket:", "http://", "https://"}
+
+// hasVersionProtocol reports whether spec starts with one of versionProtocols.
+func hasVersionProtocol(spec string) bool {
+	for _, protocol := range versionProtocols {
+		if strings.HasPrefix(spec, protocol) {
+			return true
+		}
+	}
+	return false
+}
+
+// Prefixes of a bare path to a local package.
+var localPathPrefixes = []string{"./", "../", "/", "~/"}
+
+// checkVersionSpec reports whether bun install accepts spec as a version.
+func checkVersionSpec(spec string) error {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return fmt.Errorf("empty version")
+	}
+	for _, protocol := range append(versionProtocols, localPathPrefixes...) {
+		if strings.HasPrefix(spec, protocol) {
+			return nil
+		}
+	}
+	if distTagRe.MatchString(spec) || repoShorthandRe.MatchString(spec) {
+		return nil
+	}
+	if _, err := parseRange(spec); err != nil {
+		return fmt.Errorf("%q is not a version, range or tag", spec)
+	}
+	return nil
+}
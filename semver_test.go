@@ -0,0 +1,53 @@
+package main
+
+import "testing"
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		spec    string
+		matches []string
+		rejects []string
+	}{
+		{"1.2.3", []string{"1.2.3"}, []string{"1.2.4", "1.2.2"}},
+		{"^1.2.3", []string{"1.2.3", "1.9.0"}, []string{"2.0.0", "1.2.2"}},
+		{"^0.2.3", []string{"0.2.9"}, []string{"0.3.0"}},
+		{"~1.2.3", []string{"1.2.9"}, []string{"1.3.0"}},
+		{"1.x", []string{"1.0.0", "1.99.0"}, []string{"2.0.0"}},
+		{"*", []string{"0.0.1", "9.9.9"}, nil},
+		{">=1.2 <2", []string{"1.2.0", "1.9.9"}, []string{"1.1.9", "2.0.0"}},
+		{"1.2 - 1.4", []string{"1.2.0", "1.4.9"}, []string{"1.5.0"}},
+		{"^1 || ^3", []string{"1.5.0", "3.0.0"}, []string{"2.0.0"}},
+	}
+	for _, tt := range tests {
+		r, err := parseRange(tt.spec)
+		if err != nil {
+			t.Errorf("parseRange(%q): %v", tt.spec, err)
+			continue
+		}
+		for _, v := range tt.matches {
+			if parsed, _ := parseVersion(v); !r.Matches(parsed) {
+				t.Errorf("%q does not match %s", tt.spec, v)
+			}
+		}
+		for _, v := range tt.rejects {
+			if parsed, _ := parseVersion(v); r.Matches(parsed) {
+				t.Errorf("%q matches %s", tt.spec, v)
+			}
+		}
+	}
+}
+
+func TestCheckVersionSpec(t *testing.T) {
+	valid := []string{"1.2.3", "^1.2", ">=1 <2", "latest", "next", "npm:real@1", "file:../pkg", "../pkg", "github:owner/repo#v1", "owner/repo", "https://example.com/pkg.tgz"}
+	for _, spec := range valid {
+		if err := checkVersionSpec(spec); err != nil {
+			t.Errorf("checkVersionSpec(%q): %v", spec, err)
+		}
+	}
+	invalid := []string{"", "  ", "^^1", "1.2.3.4", ">=1 <two", "1.2 || not a version"}
+	for _, spec := range invalid {
+		if err := checkVersionSpec(spec); err == nil {
+			t.Errorf("checkVersionSpec(%q) accepted an invalid spec", spec)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// defaultSidecarWait bounds how long bunv waits for a sidecar to become ready.
+const defaultSidecarWait = 30 * time.Second
+
+// A sidecar is a background command from the header's "sidecars" list.
+type sidecar struct {
+	Name    string
+	Command string
+	// WaitPort, when set, is a local TCP port that must accept connections
+	// before the script starts.
+	WaitPort int
+	// WaitLog, when set, is text the sidecar must print before the script
+	// starts.
+	WaitLog string
+	Timeout time.Duration
+
+	cmd    *exec.Cmd
+	ready  chan struct{}
+	exited chan struct{}
+	once   sync.Once
+}
+
+// extractSidecarsFromHeader parses the "sidecars" list of the script's metadata block.
+func extractSidecarsFromHeader(scriptPath string) ([]*sidecar, error) {
+	header, _ := extractHeader(scriptPath)
+	list, _ := header["sidecars"].([]any)
+	var sidecars []*sidecar
+	for i, entry := range list {
+		sc := &sidecar{Name: fmt.Sprintf("sidecar-%d", i+1), Timeout: defaultSidecarWait}
+		switch e := entry.(type) {
+		case string:
+			sc.Command = e
+		case map[string]any:
+			sc.Command, _ = e["command"].(string)
+			if name, ok := e["name"].(string); ok && name != "" {
+				sc.Name = name
+			}
+			if waitFor, ok := e["waitFor"].(map[string]any); ok {
+				if port, ok := waitFor["port"].(float64); ok {
+					sc.WaitPort = int(port)
+				}
+				sc.WaitLog, _ = waitFor["log"].(string)
+			}
+			if timeout, ok := e["timeout"].(string); ok {
+				d, err := time.ParseDuration(timeout)
+				if err != nil {
+					return nil, fmt.Errorf("sidecar %s: invalid timeout %q", sc.Name, timeout)
+				}
+				sc.Timeout = d
+			}
+		}
+		if sc.Command == "" {
+			return nil, fmt.Errorf("sidecar %s has no command", sc.Name)
+		}
+		sidecars = append(sidecars, sc)
+	}
+	return sidecars, nil
+}
+
+// Start launches the sidecar in its own process group.
+func (sc *sidecar) Start(env []string) error {
+	sc.ready = make(chan struct{})
+	sc.exited = make(chan struct{})
+	sc.cmd = exec.Command("sh", "-c", sc.Command)
+	sc.cmd.Env = env
+	setProcessGroup(sc.cmd)
+	pr, pw := io.Pipe()
+	sc.cmd.Stdout = pw
+	sc.cmd.Stderr = pw
+	if err := sc.cmd.Start(); err != nil {
+		return err
+	}
+	go func() {
+		sc.cmd.Wait()
+		close(sc.exited)
+		pw.Close()
+	}()
+	go func() {
+		scanner := bufio.NewScanner(pr)
+		for scanner.Scan() {
+			line := scanner.Text()
+			fmt.Fprintf(os.Stderr, "[%s] %s\n", sc.Name, line)
+			if sc.WaitLog != "" && strings.Contains(line, sc.WaitLog) {
+				sc.once.Do(func() { close(sc.ready) })
+			}
+		}
+		io.Copy(io.Discard, pr)
+	}()
+	return nil
+}
+
+// Wait blocks until the sidecar is ready according to its waitFor settings,
+// failing as soon as the sidecar exits.
+func (sc *sidecar) Wait() error {
+	deadline := time.After(sc.Timeout)
+	if sc.WaitLog != "" {
+		select {
+		case <-sc.ready:
+		case <-sc.exited:
+			return sc.exitError()
+		case <-deadline:
+			return fmt.Errorf("sidecar %s did not print %q within %s", sc.Name, sc.WaitLog, sc.Timeout)
+		}
+	}
+	if sc.WaitPort != 0 {
+		addr := fmt.Sprintf("127.0.0.1:%d", sc.WaitPort)
+		for {
+			conn, err := net.DialTimeout("tcp", addr, time.Second)
+			if err == nil {
+				conn.Close()
+				break
+			}
+			select {
+			case <-sc.exited:
+				return sc.exitError()
+			case <-deadline:
+				return fmt.Errorf("sidecar %s did not listen on port %d within %s", sc.Name, sc.WaitPort, sc.Timeout)
+			case <-time.After(100 * time.Millisecond):
+			}
+		}
+	}
+	return nil
+}
+
+// exitError describes a sidecar that exited before it was ready.
+func (sc *sidecar) exitError() error {
+	return fmt.Errorf("sidecar %s exited before it was ready (%s)", sc.Name, sc.cmd.ProcessState)
+}
+
+// Stop terminates the sidecar's process group, escalating to SIGKILL.
+func (sc *sidecar) Stop() {
+	if sc.cmd == nil || sc.cmd.Process == nil {
+		return
+	}
+	pid := sc.cmd.Process.Pid
+	signalProcessGroup(pid, syscall.SIGTERM)
+	for i := 0; i < 50; i++ {
+		if !processGroupExists(pid) {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	signalProcessGroup(pid, syscall.SIGKILL)
+}
+
+// startSidecars starts each sidecar in order and waits for it to be ready.
+func startSidecars(sidecars []*sidecar, env []string) error {
+	for i, sc := range sidecars {
+		err := sc.Start(env)
+		if err == nil {
+			err = sc.Wait()
+		}
+		if err != nil {
+			stopSidecars(sidecars[:i+1])
+			return err
+		}
+	}
+	return nil
+}
+
+// stopSidecars stops sidecars in reverse start order.
+func stopSidecars(sidecars []*sidecar) {
+	for i := len(sidecars) - 1; i >= 0; i-- {
+		sidecars[i].Stop()
+	}
+}
+
+// stopSidecarsOnSignal stops the sidecars and calls exit if bunv is interrupted or terminated.
+func stopSidecarsOnSignal(sidecars []*sidecar, exit func(int)) func() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case sig := <-sigs:
+			stopSidecars(sidecars)
+			exit(128 + int(sig.(syscall.Signal)))
+		case <-done:
+		}
+	}()
+	return func() {
+		signal.Stop(sigs)
+		close(done)
+	}
+}
@@ -0,0 +1,78 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSidecarsStartAndStopInOrder(t *testing.T) {
+	log := filepath.Join(t.TempDir(), "log")
+	command := func(name string) string {
+		return fmt.Sprintf("trap 'echo stop %[1]s >> %[2]s; exit 0' TERM; echo start %[1]s >> %[2]s; echo ready; while :; do sleep 0.05; done", name, log)
+	}
+	sidecars := []*sidecar{
+		{Name: "a", Command: command("a"), WaitLog: "ready", Timeout: 10 * time.Second},
+		{Name: "b", Command: command("b"), WaitLog: "ready", Timeout: 10 * time.Second},
+	}
+	if err := startSidecars(sidecars, os.Environ()); err != nil {
+		t.Fatal(err)
+	}
+	stopSidecars(sidecars)
+
+	data, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(data), "start a\nstart b\nstop b\nstop a\n"; got != want {
+		t.Errorf("sidecar log = %q, want %q", got, want)
+	}
+}
+
+func TestSidecarWaitFailsWhenSidecarExits(t *testing.T) {
+	for _, sc := range []*sidecar{
+		{Name: "log", Command: "exit 3", WaitLog: "never printed", Timeout: 10 * time.Second},
+		{Name: "port", Command: "exit 3", WaitPort: 1, Timeout: 10 * time.Second},
+	} {
+		start := time.Now()
+		err := startSidecars([]*sidecar{sc}, os.Environ())
+		if err == nil || !strings.Contains(err.Error(), "exited before it was ready") {
+			t.Errorf("%s: startSidecars() error = %v, want an early exit", sc.Name, err)
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("%s: startSidecars() took %s, want it to return when the sidecar exits", sc.Name, elapsed)
+		}
+	}
+}
+
+func TestStartSidecarsStopsEarlierOnes(t *testing.T) {
+	log := filepath.Join(t.TempDir(), "log")
+	sidecars := []*sidecar{
+		{Name: "a", Command: fmt.Sprintf("trap 'echo stop a >> %s; exit 0' TERM; echo ready; while :; do sleep 0.05; done", log), WaitLog: "ready", Timeout: 10 * time.Second},
+		{Name: "b", Command: "exit 1", WaitLog: "ready", Timeout: 10 * time.Second},
+	}
+	if err := startSidecars(sidecars, os.Environ()); err == nil {
+		t.Fatal("startSidecars() succeeded with a failing sidecar")
+	}
+	data, _ := os.ReadFile(log)
+	if string(data) != "stop a\n" {
+		t.Errorf("sidecar log = %q, want the first sidecar stopped", data)
+	}
+}
+
+func TestSandboxRejectsSidecars(t *testing.T) {
+	e := newBunvEnv(t)
+	script := e.script(t, "s.ts", "// /// script\n// {\"sidecars\": [\"touch started\"]}\n// ///\n")
+	out, code := e.run(t, "", nil, "run", "--sandbox", "--parallel-scripts", script)
+	if code == 0 || !strings.Contains(out, "none of the others can be") {
+		t.Errorf("--sandbox --parallel-scripts exited with %d:\n%s", code, out)
+	}
+	if _, err := os.Stat(filepath.Join(e.dir, "started")); err == nil {
+		t.Error("sidecar ran outside the sandbox")
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// decodeMetadata decodes the body of a metadata block as JSON, or else TOML.
+func decodeMetadata(body string) (header map[string]any, isTOML bool) {
+	if err := json.Unmarshal([]byte(body), &header); err == nil {
+		return header, false
+	}
+	var tomlHeader map[string]any
+	if _, err := toml.Decode(body, &tomlHeader); err != nil {
+		return nil, false
+	}
+	data, err := json.Marshal(tomlHeader)
+	if err != nil {
+		return nil, false
+	}
+	header = nil
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, false
+	}
+	return header, true
+}
+
+// metadataSyntaxError explains why decodeMetadata rejected body, with the line.
+func metadataSyntaxError(body string) (line int, err error) {
+	var discard map[string]any
+	if strings.HasPrefix(strings.TrimSpace(body), "{") {
+		err = json.Unmarshal([]byte(body), &discard)
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			offset := min(int(syntaxErr.Offset), len(body))
+			return 1 + strings.Count(body[:offset], "\n"), err
+		}
+		return 1, err
+	}
+	_, err = toml.Decode(body, &discard)
+	var parseErr toml.ParseError
+	if errors.As(err, &parseErr) {
+		// Drop the "toml: line N (last key ...): " prefix; the caller
+		// reports the line itself
+		msg := strings.TrimPrefix(parseErr.Error(), fmt.Sprintf("toml: line %d", parseErr.Position.Line))
+		if parseErr.LastKey != "" {
+			msg = strings.TrimPrefix(msg, fmt.Sprintf(" (last key %q)", parseErr.LastKey))
+		}
+		return parseErr.Position.Line, errors.New(strings.TrimPrefix(msg, ": "))
+	}
+	return 1, err
+}
+
+// tomlKeyOrder returns the top-level keys of a TOML metadata body in order.
+func tomlKeyOrder(body string) []string {
+	var discard map[string]any
+	meta, err := toml.Decode(body, &discard)
+	if err != nil {
+		return nil
+	}
+	var keys []string
+	seen := map[string]bool{}
+	for _, key := range meta.Keys() {
+		if len(key) > 0 && !seen[key[0]] {
+			keys = append(keys, key[0])
+			seen[key[0]] = true
+		}
+	}
+	return keys
+}
+
+// renderTOML serializes header as TOML, with plain values before tables.
+func renderTOML(header map[string]any, keys []string) (string, error) {
+	var values, tables []string
+	for _, key := range keys {
+		if isTOMLTable(header[key]) {
+			tables = append(tables, key)
+		} else {
+			values = append(values, key)
+		}
+	}
+
+	var out strings.Builder
+	for i, key := range append(values, tables...) {
+		var buf bytes.Buffer
+		enc := toml.NewEncoder(&buf)
+		enc.Indent = ""
+		if err := enc.Encode(map[string]any{key: tomlValue(header[key])}); err != nil {
+			return "", err
+		}
+		if i > 0 {
+			out.WriteString("\n")
+			if i >= len(values) {
+				// Separate tables with a blank line.
+				out.WriteString("\n")
+			}
+		}
+		out.WriteString(strings.Trim(buf.String(), "\n"))
+	}
+	return out.String(), nil
+}
+
+// isTOMLTable reports whether v is written as a TOML table.
+func isTOMLTable(v any) bool {
+	switch v := v.(type) {
+	case map[string]any:
+		return true
+	case []any:
+		if len(v) == 0 {
+			return false
+		}
+		for _, elem := range v {
+			if _, ok := elem.(map[string]any); !ok {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// tomlValue writes whole numbers as TOML integers rather than floats.
+func tomlValue(v any) any {
+	switch v := v.(type) {
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return int64(v)
+		}
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for k, elem := range v {
+			out[k] = tomlValue(elem)
+		}
+		return out
+	case []any:
+		out := make([]any, len(v))
+		for i, elem := range v {
+			out[i] = tomlValue(elem)
+		}
+		return out
+	}
+	return v
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+
+	"github.com/spf13/cobra"
+)
+
+// packageNameRe matches valid npm package names, scoped or not.
+var packageNameRe = regexp.MustCompile(`^(@[a-z0-9-~][a-z0-9-._~]*/)?[a-z0-9-~][a-z0-9-._~]*$`)
+
+// validateMetadata returns the problems found in content's metadata block.
+func validateMetadata(content string) []string {
+	body, _, _, found := splitMetadataBlock(content)
+	if !found || body == "" {
+		return nil
+	}
+	header, _ := decodeMetadata(body)
+	if header == nil {
+		return []string{"metadata block is neither valid JSON nor valid TOML"}
+	}
+
+	var problems []string
+	for _, section := range []string{"dependencies", "devDependencies"} {
+		raw, ok := header[section]
+		if !ok {
+			continue
+		}
+		deps, ok := raw.(map[string]any)
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s must be an object of package names to versions", section))
+			continue
+		}
+		names := make([]string, 0, len(deps))
+		for name := range deps {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if !packageNameRe.MatchString(name) {
+				problems = append(problems, fmt.Sprintf("%s: %q is not a valid package name", section, name))
+			}
+			version, ok := deps[name].(string)
+			if !ok {
+				problems = append(problems, fmt.Sprintf("%s: %s: version must be a string, not %v", section, name, deps[name]))
+				continue
+			}
+			if err := checkVersionSpec(version); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %s: %v", section, name, err))
+			}
+		}
+	}
+	return problems
+}
+
+var validateCmd = &cobra.Command{
+	Use:   "validate <script.ts>...",
+	Short: "Check scripts' metadata blocks for malformed dependencies",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		failed := false
+		for _, scriptFile := range args {
+			content, err := os.ReadFile(scriptFile)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error reading script file: %v\n", err)
+				failed = true
+				continue
+			}
+			problems := validateMetadata(string(content))
+			for _, problem := range problems {
+				fmt.Printf("%s: %s\n", scriptFile, problem)
+			}
+			if len(problems) > 0 {
+				failed = true
+			} else {
+				logf("%s: ok\n", scriptFile)
+			}
+		}
+		if failed {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(validateCmd)
+}
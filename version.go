@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// Build information, set with -ldflags "-X main.version=...".
+var (
+	version = "dev"
+	commit  = ""
+	date    = ""
+)
+
+// bunvVersion returns bunv's version, falling back to the module version.
+func bunvVersion() string {
+	if version == "dev" {
+		if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+			return strings.TrimPrefix(info.Main.Version, "v")
+		}
+	}
+	return version
+}
+
+// buildCommit returns the commit bunv was built from, if known.
+func buildCommit() string {
+	if commit != "" {
+		return commit
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				return setting.Value
+			}
+		}
+	}
+	return ""
+}
+
+// versionInfo describes this build of bunv and the bun it would run.
+func versionInfo() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "bunv %s\n", bunvVersion())
+	if c := buildCommit(); c != "" {
+		if date != "" {
+			fmt.Fprintf(&b, "commit: %s (built %s)\n", c, date)
+		} else {
+			fmt.Fprintf(&b, "commit: %s\n", c)
+		}
+	}
+	fmt.Fprintf(&b, "go: %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	// The config may name the bun to use.
+	if cfg, err := loadConfig(); err == nil {
+		globalConfig = cfg
+	}
+	if bunPath, err := ensureBun(); err != nil {
+		fmt.Fprintf(&b, "bun: not found\n")
+	} else if v := getBunVersion(bunPath); v != "" {
+		fmt.Fprintf(&b, "bun: %s (%s)\n", v, bunPath)
+	} else {
+		fmt.Fprintf(&b, "bun: unknown version (%s)\n", bunPath)
+	}
+	return b.String()
+}
+
+var versionCmd = &cobra.Command{
+	Use:   "version",
+	Short: "Print bunv's version and build information",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Print(versionInfo())
+	},
+}
+
+func init() {
+	rootCmd.Version = bunvVersion()
+	cobra.AddTemplateFunc("versionInfo", versionInfo)
+	rootCmd.SetVersionTemplate("{{versionInfo}}")
+	rootCmd.AddCommand(versionCmd)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"golang.org/x/term"
+)
+
+// How long the script must go unmodified before a change re-runs it.
+const watchDebounce = 200 * time.Millisecond
+
+// runWatch runs bunv with args, and again whenever scriptFile changes.
+func runWatch(scriptFile string, bunvArgs []string) error {
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	absScript, err := filepath.Abs(scriptFile)
+	if err != nil {
+		return err
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+	// Watch the directory rather than the file: editors often save by
+	// replacing the file, which would end a watch on the old one.
+	if err := watcher.Add(filepath.Dir(absScript)); err != nil {
+		return err
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+
+	// As in runBun, each run gets its own process group so that stopping it
+	// stops everything it started, unless it needs the terminal.
+	ownGroup := !term.IsTerminal(int(os.Stdin.Fd()))
+	for {
+		child := internalRunCommand(self, bunvArgs...)
+		child.Stdin = os.Stdin
+		child.Stdout = os.Stdout
+		child.Stderr = os.Stderr
+		if ownGroup {
+			setProcessGroup(child)
+		}
+		stop := func(sig os.Signal) {
+			if ownGroup {
+				signalProcessGroup(child.Process.Pid, sig.(syscall.Signal))
+			} else {
+				child.Process.Signal(sig)
+			}
+		}
+		if err := child.Start(); err != nil {
+			return err
+		}
+		exited := make(chan struct{})
+		go func() {
+			child.Wait()
+			close(exited)
+		}()
+
+		var debounce <-chan time.Time
+	wait:
+		for {
+			select {
+			case <-exited:
+				logf("--- exited with status %d; waiting for changes to %s ---\n", child.ProcessState.ExitCode(), scriptFile)
+				exited = nil
+			case event := <-watcher.Events:
+				if event.Name == absScript && event.Has(fsnotify.Write|fsnotify.Create|fsnotify.Rename) {
+					debounce = time.After(watchDebounce)
+				}
+			case err := <-watcher.Errors:
+				fmt.Fprintf(os.Stderr, "Warning: Watching %s: %v\n", scriptFile, err)
+			case <-debounce:
+				if _, err := os.Stat(absScript); err != nil {
+					// Mid-save; wait for the file to reappear.
+					continue
+				}
+				break wait
+			case sig := <-sigs:
+				if exited != nil {
+					stop(sig)
+					<-exited
+				}
+				return nil
+			}
+		}
+
+		if exited != nil {
+			stop(syscall.SIGTERM)
+			<-exited
+		}
+		logf("--- %s changed, re-running ---\n", scriptFile)
+	}
+}
+
+// withoutWatchFlag returns args without --watch, leaving script arguments alone.
+func withoutWatchFlag(args []string) []string {
+	var out []string
+	for i, arg := range args {
+		if arg == "--" {
+			return append(out, args[i:]...)
+		}
+		if arg == "--watch" || arg == "--watch=true" {
+			continue
+		}
+		out = append(out, arg)
+	}
+	return out
+}
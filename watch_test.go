@@ -0,0 +1,22 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestWithoutWatchFlag(t *testing.T) {
+	tests := []struct {
+		args, want []string
+	}{
+		{[]string{"run", "--watch", "s.ts"}, []string{"run", "s.ts"}},
+		{[]string{"run", "--watch=true", "--verbose", "s.ts"}, []string{"run", "--verbose", "s.ts"}},
+		{[]string{"run", "--watch", "--", "s.ts", "--watch"}, []string{"run", "--", "s.ts", "--watch"}},
+		{[]string{"run", "s.ts"}, []string{"run", "s.ts"}},
+	}
+	for _, tt := range tests {
+		if got := withoutWatchFlag(tt.args); !slices.Equal(got, tt.want) {
+			t.Errorf("withoutWatchFlag(%q) = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+}